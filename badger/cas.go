@@ -0,0 +1,73 @@
+package badger
+
+import (
+	b "github.com/dgraph-io/badger"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// maxRetries of a conditional write on b.ErrConflict
+const maxRetries = 100
+
+// retry the transaction while it conflicts with others
+// badger checks the keys read in the transaction on commit
+func (bdger Badger) retry(write func(txn *b.Txn) error) error {
+	for i := 0; ; i++ {
+		err := bdger.update(write)
+		if err != b.ErrConflict || i == maxRetries {
+			return err
+		}
+	}
+}
+
+// current returns the value of the key and false if it doesn't exist
+func current(txn *b.Txn, key []byte) ([]byte, bool, error) {
+	value, err := getValue(key, txn)
+	if err == dbtx.ErrNotFound {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// CompareAndSwap writes the value if the current one is the expected, inside one transaction
+// expected nil means that the key must not exist and value nil deletes the key
+// a dbtx.PreconditionError is returned if the current value isn't the expected
+func (bdger Badger) CompareAndSwap(key, expected, value []byte) error {
+	return bdger.retry(func(txn *b.Txn) error {
+		cur, found, err := current(txn, key)
+		if err != nil {
+			return err
+		}
+		if err := dbtx.CheckSwap(key, cur, found, expected); err != nil {
+			return err
+		}
+		if value == nil {
+			return txn.Delete(key)
+		}
+		return set(txn, key, value)
+	})
+}
+
+// PutIfAbsent writes the key/value if the key doesn't exist
+func (bdger Badger) PutIfAbsent(key, value []byte) error {
+	return bdger.CompareAndSwap(key, nil, value)
+}
+
+// Increment adds delta to the counter of the key, inside one transaction
+// the counter of a key that doesn't exist is zero
+func (bdger Badger) Increment(key []byte, delta int64) (int64, error) {
+	var n int64
+	err := bdger.retry(func(txn *b.Txn) error {
+		cur, found, err := current(txn, key)
+		if err != nil {
+			return err
+		}
+		if n, err = dbtx.AddCounter(key, cur, found, delta); err != nil {
+			return err
+		}
+		return set(txn, key, dbtx.EncodeCounter(n))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,62 @@
+package badger
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+
+	b "github.com/dgraph-io/badger"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// DefaultDiscardRatio of the value log GC, see Badger.DiscardRatio
+const DefaultDiscardRatio = 0.5
+
+// Compact flattens the LSM tree and runs the value log GC until no file is rewritten
+// the writes continue during the compaction, so the report is approximated
+func (bdger *Badger) Compact() (*dbtx.Compaction, error) {
+	if bdger.readOnly {
+		return nil, &dbtx.ReadOnlyError{Path: bdger.path}
+	}
+	report := &dbtx.Compaction{}
+	var err error
+	if report.Before, err = bdger.Size(); err != nil {
+		return nil, err
+	}
+
+	// the flatten moves the stale versions to the last level, so the GC can discard them
+	if err := bdger.DB.Flatten(runtime.NumCPU()); err != nil {
+		return nil, err
+	}
+	for {
+		err := bdger.DB.RunValueLogGC(bdger.DiscardRatio)
+		if err == b.ErrNoRewrite {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if report.After, err = bdger.Size(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Size of the tables and value log files
+// badger.DB.Size is only refreshed each minute, so the files are read
+func (bdger Badger) Size() (int64, error) {
+	files, err := ioutil.ReadDir(bdger.path)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	for _, f := range files {
+		switch filepath.Ext(f.Name()) {
+		case ".sst", ".vlog":
+			size += f.Size()
+		}
+	}
+	return size, nil
+}
@@ -7,7 +7,9 @@
 package badger
 
 import (
+	"bytes"
 	"fmt"
+	"os"
 
 	b "github.com/dgraph-io/badger"
 	"github.com/plateausnetwork/drivers/dbtx"
@@ -22,13 +24,54 @@ type Badger struct {
 	tp          int
 	path        string
 	IteratorOpt b.IteratorOptions
+	// SequenceBandwidth: amount of ids leased by each write of a sequence
+	SequenceBandwidth uint64
+	// DiscardRatio: min fraction of stale data of a value log file rewritten by Compact
+	DiscardRatio float64
+	watch        *watcher
+	sequences    *sequences
+	readOnly     bool
+}
+
+// DefaultDirMode of the directory created by Open
+const DefaultDirMode os.FileMode = 0700
+
+// Options of OpenWith
+// ReadOnly: opens with a shared lock of the directory, so many read-only handles can
+// read it, but it fails while the directory is open for writes, the writes
+// return a *dbtx.ReadOnlyError
+// InMemory: badger v1 has no memory mode, it returns dbtx.ErrNotSupported
+// NoSync: the commits don't wait the fsync of the value log, the last ones may be lost in a crash
+// DirMode: mode of the directory if it's created, DefaultDirMode if zero
+type Options struct {
+	ReadOnly bool
+	InMemory bool
+	NoSync   bool
+	DirMode  os.FileMode
 }
 
 // Open client by given path
 func Open(tp int, filepath string) (*Badger, error) {
+	return OpenWith(tp, filepath, Options{})
+}
+
+// OpenWith opens the client by given path with the options
+func OpenWith(tp int, filepath string, opts Options) (*Badger, error) {
+	if opts.InMemory {
+		return nil, fmt.Errorf("badger v1 has no in-memory mode: %w", dbtx.ErrNotSupported)
+	}
 	if filepath == "" {
 		return nil, fmt.Errorf("empty path")
 	}
+	if !opts.ReadOnly {
+		mode := opts.DirMode
+		if mode == 0 {
+			mode = DefaultDirMode
+		}
+		if err := os.MkdirAll(filepath, mode); err != nil {
+			return nil, err
+		}
+	}
 
 	// set default iterator options for Badger
 	iteratorOpt := b.IteratorOptions{
@@ -38,13 +81,18 @@ func Open(tp int, filepath string) (*Badger, error) {
 		AllVersions:    false,
 	}
 
-	db, err := b.Open(b.DefaultOptions(filepath))
+	db, err := b.Open(b.DefaultOptions(filepath).WithReadOnly(opts.ReadOnly).WithSyncWrites(!opts.NoSync))
 	return &Badger{
-		DB:          db,
-		opened:      true,
-		tp:          tp,
-		path:        filepath,
-		IteratorOpt: iteratorOpt,
+		DB:                db,
+		opened:            true,
+		tp:                tp,
+		path:              filepath,
+		IteratorOpt:       iteratorOpt,
+		SequenceBandwidth: DefaultSequenceBandwidth,
+		DiscardRatio:      DefaultDiscardRatio,
+		watch:             newWatcher(),
+		sequences:         &sequences{leases: make(map[string]*sequence)},
+		readOnly:          opts.ReadOnly,
 	}, err
 }
 
@@ -55,12 +103,15 @@ func (bdger *Badger) Open() bool {
 
 // Clean buckets
 func (bdger *Badger) Clean() {
-	bdger.DB.Update(func(txn *b.Txn) error { //nolint:errcheck
+	bdger.update(func(txn *b.Txn) error { //nolint:errcheck
 		it := txn.NewIterator(bdger.IteratorOpt)
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			err := txn.Delete(item.Key())
+			if reserved(it.Item().Key()) {
+				continue
+			}
+			// the key of the item is reused by the iterator
+			err := txn.Delete(it.Item().KeyCopy(nil))
 			if err != nil {
 				return err
 			}
@@ -70,21 +121,41 @@ func (bdger *Badger) Clean() {
 }
 
 // Close the database
+// the channels of the watchers are closed and the leases of the sequences are released
 func (bdger *Badger) Close() error {
 	bdger.opened = false
+	bdger.watch.close()
+	if err := bdger.sequences.release(); err != nil {
+		bdger.DB.Close() // nolint
+		return err
+	}
 	return bdger.DB.Close()
 }
 
+// update runs the write transaction, the read-only databases return a *dbtx.ReadOnlyError
+func (bdger Badger) update(write func(txn *b.Txn) error) error {
+	if bdger.readOnly {
+		return &dbtx.ReadOnlyError{Path: bdger.path}
+	}
+	return bdger.DB.Update(write)
+}
+
+// getValue returns a copy of the value, badger values are only valid inside of the transaction
 func getValue(key []byte, txn *b.Txn) ([]byte, error) {
 	item, err := txn.Get(key)
+	if err == b.ErrKeyNotFound {
+		return nil, dbtx.ErrNotFound
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Inexistent key")
+		return nil, err
 	}
-	var encodedValue []byte
-	return encodedValue, item.Value(func(val []byte) error {
-		encodedValue = val
-		return nil
-	})
+	return item.ValueCopy(nil)
+}
+
+// reserved returns true for the keys of the sequences and of Watch, they aren't
+// key/values of the database, so the iterations, Length and Clean skip them
+func reserved(key []byte) bool {
+	return bytes.HasPrefix(key, dbtx.SequencePrefix) || bytes.HasPrefix(key, watchPrefix)
 }
 
 // Type setted by caller
@@ -92,14 +163,14 @@ func (bdger Badger) Type() int {
 	return bdger.tp
 }
 
-// Size of database
-func (bdger Badger) Size() (int64, error) {
-	return 0, nil // TODO: implement this
-}
-
 // Length amount of keys
 func (bdger Badger) Length() int {
-	return 0 // TODO: implement this
+	var len int
+	bdger.KeyIterator(func([]byte) error { //nolint:errcheck
+		len++
+		return nil
+	})
+	return len
 }
 
 // Path returns the full path
@@ -125,11 +196,16 @@ func (bdger Badger) ForEach(query func([]byte) error) error {
 		it := txn.NewIterator(bdger.IteratorOpt)
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			err := item.Value(query)
+			if reserved(it.Item().Key()) {
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
 			if err != nil {
 				return err
 			}
+			if err := query(value); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -137,32 +213,39 @@ func (bdger Badger) ForEach(query func([]byte) error) error {
 
 // KeyIterator iterates only in keys
 func (bdger Badger) KeyIterator(query func([]byte) error) error {
-	var err error
 	return bdger.DB.View(func(txn *b.Txn) error {
 		it := txn.NewIterator(bdger.IteratorOpt)
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			err = query(k)
+			if reserved(it.Item().Key()) {
+				continue
+			}
+			if err := query(it.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
 		}
-		return err
+		return nil
 	})
 }
 
 // Upsert update or insert the key/value
 func (bdger Badger) Upsert(k, v []byte) error {
-	return bdger.DB.Update(func(txn *b.Txn) error {
-		return txn.Set(k, v)
+	return bdger.update(func(txn *b.Txn) error {
+		return set(txn, k, v)
 	})
 }
 
+// set the key/value with the meta of the puts, used by Watch
+func set(txn *b.Txn, key, value []byte) error {
+	return txn.SetEntry(b.NewEntry(key, value).WithMeta(putMeta))
+}
+
 // Update updates all database executions inside one transaction
 func (bdger Badger) Update(execute dbtx.Execute) error {
-	return bdger.DB.Update(func(txn *b.Txn) error {
+	return bdger.update(func(txn *b.Txn) error {
 		return execute(dbtx.BucketImp{ // actual implementation of bucket
 			PutImp: func(key []byte, val []byte) error {
-				return txn.Set(key, val)
+				return set(txn, key, val)
 			},
 			DeleteImp: func(key []byte) error {
 				return txn.Delete(key)
@@ -173,7 +256,7 @@ func (bdger Badger) Update(execute dbtx.Execute) error {
 
 // Delete key/value from database
 func (bdger Badger) Delete(key []byte) error {
-	return bdger.DB.Update(func(txn *b.Txn) error {
+	return bdger.update(func(txn *b.Txn) error {
 		return txn.Delete(key)
 	})
 }
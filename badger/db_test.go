@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"testing"
+	"time"
 
 	b "github.com/plateausnetwork/drivers/badger"
 	"github.com/plateausnetwork/drivers/runners"
+	"github.com/plateausnetwork/drivers/watch"
 )
 
 var key = []byte("key")
@@ -125,3 +127,163 @@ func TestForEach(t *testing.T) {
 		}
 	})
 }
+
+// the puts of empty values aren't published as deletes
+// the channels are closed with the database
+func TestWatch(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db, err := b.Open(tp, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		events, _ := db.Watch(nil)
+		if err := db.Upsert(key, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if e := <-events; e.Op != watch.Put || !bytes.Equal(e.Key, key) {
+			t.Errorf("expected the put of %q, got %s %q", key, e.Op, e.Key)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := <-events; ok {
+			t.Error("the channel wasn't closed")
+		}
+	})
+}
+
+// the later subscribers don't receive the commits before their Watch
+func TestWatchAfterCommits(t *testing.T) {
+	withBadger(func(db *b.Badger) {
+		first, _ := db.Watch(nil)
+		for i := 0; i < 200; i++ {
+			if err := db.Upsert([]byte(fmt.Sprintf("before/%03d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		second, _ := db.Watch(nil)
+		if err := db.Upsert([]byte("after"), value); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case e := <-second:
+			if string(e.Key) != "after" {
+				t.Errorf("expected the put of after, got %s %q", e.Op, e.Key)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("the event wasn't received")
+		}
+		if e := <-first; string(e.Key) != "before/000" {
+			t.Errorf("expected the put of before/000, got %s %q", e.Op, e.Key)
+		}
+	})
+}
+
+// the keys are deleted in more than one chunk
+func TestDeletePrefixChunks(t *testing.T) {
+	withBadger(func(db *b.Badger) {
+		for i := 0; i < 2500; i++ {
+			if err := db.Upsert([]byte(fmt.Sprintf("block/%05d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		count, err := db.DeletePrefix([]byte("block/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 2500 {
+			t.Errorf("expected 2500 deleted keys, got %d", count)
+		}
+		if count, _ := db.CountPrefix(nil); count != 1 {
+			t.Errorf("only the key out of the prefix must remain, got %d keys", count)
+		}
+	})
+}
+
+// the ids are unique after a crash, without the release of the lease
+func TestSequenceCrash(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db, err := b.Open(tp, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SequenceBandwidth = 10
+		seq, err := db.Sequence([]byte("ids"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var last uint64
+		for i := 0; i < 15; i++ {
+			if last, err = seq.Next(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.DB.Close() // nolint
+
+		db, err = b.Open(tp, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		seq, err = db.Sequence([]byte("ids"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := seq.Next(); err != nil || n <= last {
+			t.Errorf("expected an id greater than %d, got %d %v", last, n, err)
+		}
+	})
+}
+
+// the compaction keeps the key/values
+func TestCompact(t *testing.T) {
+	withBadger(func(db *b.Badger) {
+		for i := 0; i < 1000; i++ {
+			if err := db.Upsert([]byte(fmt.Sprintf("block/%05d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := db.DeletePrefix([]byte("block/")); err != nil {
+			t.Fatal(err)
+		}
+
+		report, err := db.Compact()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Before <= 0 {
+			t.Errorf("the size of the files must be reported %+v", report)
+		}
+		if v, err := db.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Errorf("wrong value after the compaction %v", err)
+		}
+	})
+}
+
+// the reopen writes the memtable in a table, so the tables are verified
+func TestVerify(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db, err := b.Open(tp, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		db, err = b.Open(tp, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		report, err := db.Verify()
+		if err != nil || !report.Healthy() || report.Keys != 1 {
+			t.Errorf("wrong report of a healthy database %+v %v", report, err)
+		}
+	})
+}
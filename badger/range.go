@@ -0,0 +1,104 @@
+package badger
+
+import (
+	"bytes"
+
+	b "github.com/dgraph-io/badger"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// deleteChunk is the amount of keys read before each delete
+const deleteChunk = 1000
+
+// DeletePrefix deletes the keys with the prefix, see DeleteRange
+func (bdger Badger) DeletePrefix(prefix []byte) (int, error) {
+	return bdger.DeleteRange(prefix, dbtx.PrefixEnd(prefix))
+}
+
+// DeleteRange deletes the keys from start until end (exclusive)
+// end nil deletes until the last key
+// the keys are deleted in chunks, a transaction is committed when it's too big for badger,
+// so the deletes aren't atomic
+// DB.DropPrefix isn't used, it blocks the writes and its deletes aren't published to the watchers
+func (bdger Badger) DeleteRange(start, end []byte) (int, error) {
+	count := 0
+	for {
+		keys, err := bdger.keys(start, end, deleteChunk)
+		if err != nil || len(keys) == 0 {
+			return count, err
+		}
+		if err := bdger.deleteKeys(keys); err != nil {
+			return count, err
+		}
+		count += len(keys)
+		if len(keys) < deleteChunk {
+			return count, nil
+		}
+		// the deleted keys aren't read again
+		start = keys[len(keys)-1]
+	}
+}
+
+// keys returns up to limit keys from start until end, without the reserved ones
+func (bdger Badger) keys(start, end []byte, limit int) ([][]byte, error) {
+	var keys [][]byte
+	err := bdger.DB.View(func(txn *b.Txn) error {
+		it := txn.NewIterator(bdger.IteratorOpt)
+		defer it.Close()
+		for it.Seek(start); it.Valid() && len(keys) < limit; it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if end != nil && bytes.Compare(key, end) >= 0 {
+				break
+			}
+			if reserved(key) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// deleteKeys commits the transaction and starts a new one when it's too big
+func (bdger Badger) deleteKeys(keys [][]byte) error {
+	if bdger.readOnly {
+		return &dbtx.ReadOnlyError{Path: bdger.path}
+	}
+	txn := bdger.DB.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	for _, key := range keys {
+		err := txn.Delete(key)
+		if err == b.ErrTxnTooBig {
+			if err := txn.Commit(); err != nil {
+				return err
+			}
+			txn = bdger.DB.NewTransaction(true)
+			err = txn.Delete(key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// CountPrefix returns the amount of keys with the prefix
+func (bdger Badger) CountPrefix(prefix []byte) (int, error) {
+	count := 0
+	err := bdger.DB.View(func(txn *b.Txn) error {
+		opts := bdger.IteratorOpt
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			if reserved(it.Item().Key()) {
+				continue
+			}
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
@@ -0,0 +1,57 @@
+package badger
+
+import (
+	"bytes"
+
+	b "github.com/dgraph-io/badger"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Scan returns a page of key/values, see dbtx.ScanOptions
+func (bdger Badger) Scan(opts dbtx.ScanOptions) (*dbtx.Page, error) {
+	after, err := dbtx.DecodeToken(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pager := dbtx.NewPager(opts)
+	err = bdger.DB.View(func(txn *b.Txn) error {
+		itOpts := bdger.IteratorOpt
+		itOpts.Reverse = opts.Reverse
+		it := txn.NewIterator(itOpts)
+		defer it.Close()
+
+		// the seek is inclusive, the key of the token or the end of the prefix is skipped
+		start := after
+		if start == nil && opts.Reverse {
+			start = dbtx.PrefixEnd(opts.Prefix)
+		} else if start == nil {
+			start = opts.Prefix
+		}
+		if start == nil {
+			it.Rewind()
+		} else {
+			it.Seek(start)
+		}
+		if (after != nil || opts.Reverse) && it.Valid() && bytes.Equal(it.Item().Key(), start) {
+			it.Next()
+		}
+
+		for ; it.ValidForPrefix(opts.Prefix); it.Next() {
+			if reserved(it.Item().Key()) {
+				continue
+			}
+			if pager.Full() {
+				break
+			}
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			pager.Add(item.Key(), value)
+		}
+		return nil
+	})
+	return pager.Page(), err
+}
@@ -0,0 +1,75 @@
+package badger
+
+import (
+	"fmt"
+	"sync"
+
+	b "github.com/dgraph-io/badger"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// DefaultSequenceBandwidth is the amount of ids leased by each write of a sequence
+const DefaultSequenceBandwidth = 1000
+
+// sequences opened by the database, one for each name
+// the same name can't have two leases, or its ids wouldn't be monotonic
+type sequences struct {
+	leases map[string]*sequence
+	sync.Mutex
+}
+
+// sequence of badger, the ids start at 1 like the other drivers
+type sequence struct {
+	*b.Sequence
+}
+
+// Next id of the sequence
+func (seq sequence) Next() (uint64, error) {
+	n, err := seq.Sequence.Next()
+	if err == nil && n == 0 {
+		return seq.Sequence.Next()
+	}
+	return n, err
+}
+
+// Sequence returns the sequence of the name
+// it's stored in the key of the name with dbtx.SequencePrefix, by DB.GetSequence,
+// the key is hidden from the iterations and isn't deleted by Clean
+// the ids are leased by SequenceBandwidth, the unused ones are released on Close
+func (bdger *Badger) Sequence(name []byte) (dbtx.Sequence, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("badger: empty sequence name")
+	}
+	if bdger.readOnly {
+		return nil, &dbtx.ReadOnlyError{Path: bdger.path}
+	}
+
+	seqs := bdger.sequences
+	seqs.Lock()
+	defer seqs.Unlock()
+	if seq, ok := seqs.leases[string(name)]; ok {
+		return seq, nil
+	}
+
+	key := append(append([]byte{}, dbtx.SequencePrefix...), name...)
+	lease, err := bdger.DB.GetSequence(key, bdger.SequenceBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	seq := &sequence{lease}
+	seqs.leases[string(name)] = seq
+	return seq, nil
+}
+
+// release the leases of all sequences
+func (seqs *sequences) release() error {
+	seqs.Lock()
+	defer seqs.Unlock()
+	for name, seq := range seqs.leases {
+		if err := seq.Release(); err != nil {
+			return err
+		}
+		delete(seqs.leases, name)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package badger
+
+import (
+	"os"
+	"path/filepath"
+
+	b "github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/options"
+	"github.com/dgraph-io/badger/table"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Verify checks the tables of the MANIFEST with their checksums and reads all
+// key/values, so the pointers to the value log are followed
+// the tables removed by a compaction during the verification are ignored
+func (bdger *Badger) Verify() (*dbtx.Verification, error) {
+	report := &dbtx.Verification{}
+	bdger.verifyTables(report)
+
+	err := bdger.DB.View(func(txn *b.Txn) error {
+		opt := b.DefaultIteratorOptions
+		opt.PrefetchValues = false
+		it := txn.NewIterator(opt)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			err := dbtx.Safely(func() error {
+				return item.Value(func([]byte) error { return nil })
+			})
+			if err != nil {
+				report.Add("scan", item.Key(), err)
+				continue
+			}
+			report.Keys++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// verifyTables opens each table of the MANIFEST, the checksum is verified by table.OpenTable
+func (bdger *Badger) verifyTables(report *dbtx.Verification) {
+	fp, err := os.Open(filepath.Join(bdger.path, b.ManifestFilename))
+	if err != nil {
+		report.Add("manifest", nil, err)
+		return
+	}
+	defer fp.Close()
+	manifest, _, err := b.ReplayManifestFile(fp)
+	if err != nil {
+		report.Add("manifest", nil, err)
+		return
+	}
+
+	for id, tm := range manifest.Tables {
+		fd, err := os.Open(table.NewFilename(id, bdger.path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			report.Add("table", []byte(table.IDToFilename(id)), err)
+			continue
+		}
+		// the table owns the file, except when it fails
+		err = dbtx.Safely(func() error {
+			t, err := table.OpenTable(fd, options.LoadToRAM, tm.Checksum)
+			if err != nil {
+				return err
+			}
+			return t.Close()
+		})
+		if err != nil {
+			fd.Close() // nolint
+			report.Add("table", []byte(table.IDToFilename(id)), err)
+		}
+	}
+}
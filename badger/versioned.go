@@ -0,0 +1,127 @@
+package badger
+
+import (
+	"fmt"
+	"math"
+
+	b "github.com/dgraph-io/badger"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Versioned is the badger in versioned mode
+// it uses the managed mode of badger, the height is the timestamp of the transactions
+// all versions are kept until Prune
+// badger only keeps the last version at or below the discard timestamp, set by Prune,
+// the versions after it are always kept
+type Versioned struct {
+	DB   *b.DB
+	tp   int
+	path string
+}
+
+// OpenVersioned client by given path in versioned mode
+func OpenVersioned(tp int, filepath string) (*Versioned, error) {
+	if filepath == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+
+	opts := b.DefaultOptions(filepath)
+	opts.NumVersionsToKeep = 1
+	db, err := b.OpenManaged(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Versioned{DB: db, tp: tp, path: filepath}, nil
+}
+
+// timestamp of the height, badger doesn't read the timestamp zero
+func timestamp(height uint64) uint64 {
+	if height == math.MaxUint64 {
+		return height
+	}
+	return height + 1
+}
+
+// Type setted by caller
+func (v *Versioned) Type() int {
+	return v.tp
+}
+
+// Path returns the full path
+func (v *Versioned) Path() string {
+	return v.path
+}
+
+// Close the database
+func (v *Versioned) Close() error {
+	return v.DB.Close()
+}
+
+// UpsertAt update or insert the key/value at the height
+func (v *Versioned) UpsertAt(height uint64, key, value []byte) error {
+	return v.UpdateAt(height, func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, value)
+	})
+}
+
+// DeleteAt deletes the key/value at the height
+func (v *Versioned) DeleteAt(height uint64, key []byte) error {
+	return v.UpdateAt(height, func(bkt dbtx.Bucket) error {
+		return bkt.Delete(key)
+	})
+}
+
+// UpdateAt updates all executions inside one transaction at the height
+func (v *Versioned) UpdateAt(height uint64, execute dbtx.Execute) error {
+	ts := timestamp(height)
+	txn := v.DB.NewTransactionAt(ts, true)
+	defer txn.Discard()
+
+	if err := execute(dbtx.BucketImp{
+		PutImp: func(key []byte, val []byte) error {
+			return txn.Set(key, val)
+		},
+		DeleteImp: func(key []byte) error {
+			return txn.Delete(key)
+		},
+	}); err != nil {
+		return err
+	}
+	return txn.CommitAt(ts, nil)
+}
+
+// GetAt the value of the key at the height
+func (v *Versioned) GetAt(key []byte, height uint64) ([]byte, error) {
+	txn := v.DB.NewTransactionAt(timestamp(height), false)
+	defer txn.Discard()
+	return getValue(key, txn)
+}
+
+// ForEachAt key/value at the height
+func (v *Versioned) ForEachAt(height uint64, query func(key, value []byte) error) error {
+	txn := v.DB.NewTransactionAt(timestamp(height), false)
+	defer txn.Discard()
+
+	it := txn.NewIterator(b.DefaultIteratorOptions)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if err := query(item.KeyCopy(nil), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prune allows badger to discard the versions not needed to read at the height or later
+// the versions are removed by the compactions of badger, the reads before the
+// height return the removed versions until they run
+// the height isn't stored, Prune must be called again after opening
+func (v *Versioned) Prune(height uint64) error {
+	v.DB.SetDiscardTs(timestamp(height))
+	return nil
+}
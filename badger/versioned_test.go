@@ -0,0 +1,125 @@
+package badger_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	b "github.com/plateausnetwork/drivers/badger"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+func withVersioned(handler func(*b.Versioned)) {
+	runners.WithTempDir(func(dir string) {
+		db, err := b.OpenVersioned(tp, dir)
+		if err != nil {
+			panic(err)
+		}
+		defer db.Close()
+
+		handler(db)
+	})
+}
+
+func TestVersionedOpenErr(t *testing.T) {
+	if _, err := b.OpenVersioned(tp, ""); err == nil {
+		t.Error("wrong path must return an error")
+	}
+}
+
+func TestVersions(t *testing.T) {
+	withVersioned(func(db *b.Versioned) {
+		for h, v := range []string{"v0", "v1", "v2"} {
+			if err := db.UpsertAt(uint64(h*10), key, []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.DeleteAt(30, key); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[uint64]string{0: "v0", 9: "v0", 10: "v1", 25: "v2"}
+		for h, v := range expected {
+			got, err := db.GetAt(key, h)
+			if err != nil || !bytes.Equal(got, []byte(v)) {
+				t.Errorf("height %d: expected %s, received %s %v", h, v, got, err)
+			}
+		}
+
+		if _, err := db.GetAt(key, 30); !errors.Is(err, dbtx.ErrNotFound) {
+			t.Errorf("the key must be deleted: %v", err)
+		}
+
+		count := 0
+		if err := db.ForEachAt(10, func(k, v []byte) error {
+			count++
+			if !bytes.Equal(v, []byte("v1")) {
+				t.Errorf("invalid value at height 10: %s", v)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1 {
+			t.Errorf("expected 1 key at height 10, received %d", count)
+		}
+
+		if err := db.Prune(20); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := db.GetAt(key, 20); err != nil || !bytes.Equal(v, []byte("v2")) {
+			t.Errorf("height 20 must be available after prune: %v", err)
+		}
+	})
+}
+
+func TestPrune(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		open := func() *b.Versioned {
+			db, err := b.OpenVersioned(tp, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return db
+		}
+		// the close compacts the level 0 of badger, with the discard timestamp
+		db := open()
+		for h := uint64(0); h < 60; h += 10 {
+			if err := db.UpsertAt(h, key, []byte{byte(h)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db = open()
+		if err := db.Prune(30); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetAt(key, 10); err != nil {
+			t.Fatalf("the version must be read until the compaction: %v", err)
+		}
+		// the new table overlaps the pruned versions
+		if err := db.UpsertAt(60, key, []byte{60}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db = open()
+		defer db.Close()
+		for _, h := range []uint64{0, 10, 29} {
+			if _, err := db.GetAt(key, h); !errors.Is(err, dbtx.ErrNotFound) {
+				t.Errorf("height %d must be pruned: %v", h, err)
+			}
+		}
+		for h, expected := range map[uint64]byte{30: 30, 45: 40, 60: 60} {
+			if v, err := db.GetAt(key, h); err != nil || !bytes.Equal(v, []byte{expected}) {
+				t.Errorf("height %d: expected %d, received %v %v", h, expected, v, err)
+			}
+		}
+	})
+}
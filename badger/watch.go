@@ -0,0 +1,225 @@
+package badger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	b "github.com/dgraph-io/badger"
+	"github.com/plateausnetwork/drivers/watch"
+)
+
+// putMeta is the user meta of the puts
+// badger publishes the deletes as entries with empty values, the meta tells them apart
+const putMeta byte = 1
+
+var (
+	errSubscription = errors.New("badger: the subscription ended")
+	// internal keys of badger, like the end of the transactions
+	internalPrefix = []byte("!badger!")
+	// watchPrefix of the keys written by Watch, hidden like the sequences
+	watchPrefix = []byte("\xffwatch\x00")
+	// markerKey is deleted by Watch until the subscription receives it
+	markerKey = append(append([]byte{}, watchPrefix...), "marker"...)
+	// fencePrefix of the keys deleted by each Watch, see fence
+	fencePrefix = append(append([]byte{}, watchPrefix...), "fence"...)
+)
+
+// watcher publishes the changes received by badger DB.Subscribe
+// the subscription starts with the first Watch and ends with Close
+// fences: subscribers waiting their fence keys, by key
+type watcher struct {
+	hub     *watch.Hub
+	started bool
+	ready   chan struct{}
+	done    chan struct{}
+	cancel  context.CancelFunc
+	once    sync.Once
+	fences  map[string]*fence
+	seq     uint64
+	fenceMu sync.Mutex
+	sync.Mutex
+}
+
+// fence of a subscriber, the events and cancel are set when it's registered
+type fence struct {
+	prefix []byte
+	events <-chan watch.Event
+	cancel func()
+	ready  chan struct{}
+}
+
+func newWatcher() *watcher {
+	return &watcher{
+		hub:    watch.NewHub(),
+		ready:  make(chan struct{}),
+		done:   make(chan struct{}),
+		fences: make(map[string]*fence),
+	}
+}
+
+// Watch returns the events of the keys with the prefix, committed after Watch returns
+// the events of one transaction are sorted by key and only the last write of a key is published
+// the slow subscribers are dropped, see the watch package
+func (bdger *Badger) Watch(prefix []byte) (<-chan watch.Event, func()) {
+	if bdger.opened && bdger.subscribe() == nil {
+		if events, cancel, err := bdger.fence(prefix); err == nil {
+			return events, cancel
+		}
+	}
+	events, cancel := bdger.watch.hub.Watch(prefix)
+	cancel()
+	return events, cancel
+}
+
+// fence registers the subscriber when the subscription receives the delete of
+// its fence key, after the events of the previous commits, so they aren't
+// delivered to it
+func (bdger *Badger) fence(prefix []byte) (<-chan watch.Event, func(), error) {
+	w := bdger.watch
+	f := &fence{prefix: prefix, ready: make(chan struct{})}
+	w.fenceMu.Lock()
+	w.seq++
+	key := make([]byte, len(fencePrefix)+8)
+	binary.BigEndian.PutUint64(key[copy(key, fencePrefix):], w.seq)
+	w.fences[string(key)] = f
+	w.fenceMu.Unlock()
+
+	err := bdger.update(func(txn *b.Txn) error {
+		return txn.Delete(key)
+	})
+	if err == nil {
+		select {
+		case <-f.ready:
+			return f.events, f.cancel, nil
+		case <-w.done:
+			err = errSubscription
+		}
+	}
+
+	// the fence may be received after the failure
+	w.fenceMu.Lock()
+	delete(w.fences, string(key))
+	w.fenceMu.Unlock()
+	if f.cancel != nil {
+		f.cancel()
+	}
+	return nil, nil, err
+}
+
+// register the subscriber of the fence key, if it's one
+func (w *watcher) register(key []byte) {
+	w.fenceMu.Lock()
+	defer w.fenceMu.Unlock()
+	f, ok := w.fences[string(key)]
+	if !ok {
+		return
+	}
+	delete(w.fences, string(key))
+	f.events, f.cancel = w.hub.Watch(f.prefix)
+	close(f.ready)
+}
+
+// subscribe starts the subscription and waits until badger delivers the changes
+// badger registers the subscription in background, so the marker key is deleted
+// until the subscription receives it
+func (bdger *Badger) subscribe() error {
+	w := bdger.watch
+	w.Lock()
+	defer w.Unlock()
+	if w.started {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		defer close(w.done)
+		bdger.DB.Subscribe(ctx, w.publish, []byte{}) //nolint:errcheck
+	}()
+
+	for {
+		if err := bdger.update(func(txn *b.Txn) error {
+			return txn.Delete(markerKey)
+		}); err != nil {
+			cancel()
+			return err
+		}
+
+		select {
+		case <-w.ready:
+			w.started, w.cancel = true, cancel
+			return nil
+		case <-w.done:
+			cancel()
+			return errSubscription
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// publish the key/values received from badger, in the order of the commits
+func (w *watcher) publish(list *b.KVList) error {
+	events := make([]watch.Event, 0, len(list.Kv))
+	start, version := 0, uint64(0)
+	for _, kv := range list.Kv {
+		if bytes.Equal(kv.Key, markerKey) {
+			w.once.Do(func() { close(w.ready) })
+			continue
+		}
+		// the events before the fence are published before its subscriber is registered
+		if bytes.HasPrefix(kv.Key, fencePrefix) {
+			sortEvents(events[start:])
+			if len(events) > 0 {
+				w.hub.Publish(events...)
+			}
+			events, start = events[:0:0], 0
+			w.register(kv.Key)
+			continue
+		}
+		// the sequences are written without the meta of the puts
+		if bytes.HasPrefix(kv.Key, internalPrefix) || reserved(kv.Key) {
+			continue
+		}
+
+		// the key/values of one transaction have the same version
+		if kv.Version != version {
+			sortEvents(events[start:])
+			start, version = len(events), kv.Version
+		}
+
+		if len(kv.Meta) > 0 && kv.Meta[0] == putMeta {
+			events = append(events, watch.Event{Op: watch.Put, Key: kv.Key, Value: kv.Value})
+		} else {
+			events = append(events, watch.Event{Op: watch.Delete, Key: kv.Key})
+		}
+	}
+	sortEvents(events[start:])
+
+	if len(events) > 0 {
+		w.hub.Publish(events...)
+	}
+	return nil
+}
+
+// sortEvents of one transaction, badger doesn't keep the order of the writes
+func sortEvents(events []watch.Event) {
+	sort.Slice(events, func(i, j int) bool {
+		return bytes.Compare(events[i].Key, events[j].Key) < 0
+	})
+}
+
+// close ends the subscription and closes the channels of the subscribers
+func (w *watcher) close() {
+	w.Lock()
+	defer w.Unlock()
+	if w.started {
+		w.cancel()
+		<-w.done
+		w.started = false
+	}
+	w.hub.Close()
+}
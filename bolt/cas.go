@@ -0,0 +1,45 @@
+package bolt
+
+import (
+	"github.com/plateausnetwork/drivers/dbtx"
+	b "go.etcd.io/bbolt"
+)
+
+// CompareAndSwap writes the value if the current one is the expected, inside one transaction
+// expected nil means that the key must not exist and value nil deletes the key
+// a dbtx.PreconditionError is returned if the current value isn't the expected
+func (blt Bolt) CompareAndSwap(key, expected, value []byte) error {
+	return blt.update(func(raw *b.Bucket, bkt dbtx.Bucket) error {
+		current := raw.Get(key)
+		if err := dbtx.CheckSwap(key, current, current != nil, expected); err != nil {
+			return err
+		}
+		if value == nil {
+			return bkt.Delete(key)
+		}
+		return bkt.Put(key, value)
+	})
+}
+
+// PutIfAbsent writes the key/value if the key doesn't exist
+func (blt Bolt) PutIfAbsent(key, value []byte) error {
+	return blt.CompareAndSwap(key, nil, value)
+}
+
+// Increment adds delta to the counter of the key, inside one transaction
+// the counter of a key that doesn't exist is zero
+func (blt Bolt) Increment(key []byte, delta int64) (int64, error) {
+	var n int64
+	err := blt.update(func(raw *b.Bucket, bkt dbtx.Bucket) error {
+		current := raw.Get(key)
+		var err error
+		if n, err = dbtx.AddCounter(key, current, current != nil, delta); err != nil {
+			return err
+		}
+		return bkt.Put(key, dbtx.EncodeCounter(n))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
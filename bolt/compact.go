@@ -0,0 +1,275 @@
+package bolt
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+	b "go.etcd.io/bbolt"
+)
+
+// compactTxSize is the amount of bytes copied by each transaction of a compaction
+var compactTxSize = 64 << 20
+
+// file of the database, shared by the copies of Bolt
+// active counts the running calls, Compact waits until it's zero and the calls
+// started while it runs wait until the swap is done, so the calls made inside of
+// the transactions and the callbacks never wait for Compact
+// mode and options reopen the file after the swap
+type file struct {
+	db       *b.DB
+	mode     os.FileMode
+	options  *b.Options
+	active   int
+	swapping bool
+	mu       sync.Mutex
+	cond     *sync.Cond
+}
+
+func newFile(db *b.DB, mode os.FileMode, options *b.Options) *file {
+	f := &file{db: db, mode: mode, options: options}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// enter waits for the swap and returns the current db
+func (f *file) enter() *b.DB {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for f.swapping {
+		f.cond.Wait()
+	}
+	f.active++
+	return f.db
+}
+
+func (f *file) leave() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.active--; f.active == 0 {
+		f.cond.Broadcast()
+	}
+}
+
+// swap executes fn when no call is running, the new calls wait until it's done
+func (f *file) swap(fn func() error) error {
+	f.mu.Lock()
+	for f.swapping || f.active > 0 {
+		f.cond.Wait()
+	}
+	f.swapping = true
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.swapping = false
+		f.cond.Broadcast()
+		f.mu.Unlock()
+	}()
+	return fn()
+}
+
+func (f *file) view(fn func(*b.Tx) error) error {
+	db := f.enter()
+	defer f.leave()
+	return db.View(fn)
+}
+
+func (f *file) update(fn func(*b.Tx) error) error {
+	db := f.enter()
+	defer f.leave()
+	if db.IsReadOnly() {
+		return &dbtx.ReadOnlyError{Path: db.Path()}
+	}
+	return db.Update(fn)
+}
+
+func (f *file) close() error {
+	return f.swap(func() error {
+		return f.db.Close()
+	})
+}
+
+// Compact rewrites the database into a fresh file and swaps it with the current one
+// it waits until the running calls are done and the new ones wait until the swap,
+// the database can be called inside of the transactions and callbacks
+// if the swapped file can't be opened the database is closed, Open returns false
+func (blt *Bolt) Compact() (*dbtx.Compaction, error) {
+	var report *dbtx.Compaction
+	err := blt.file.swap(func() error {
+		var err error
+		report, err = blt.compact()
+		return err
+	})
+	return report, err
+}
+
+func (blt *Bolt) compact() (*dbtx.Compaction, error) {
+	if blt.file.db.IsReadOnly() {
+		return nil, &dbtx.ReadOnlyError{Path: blt.path}
+	}
+
+	report := &dbtx.Compaction{}
+	var err error
+	if report.Before, err = fileSize(blt.path); err != nil {
+		return nil, err
+	}
+	tmp := blt.path + ".compact"
+	if err := compactTo(blt.file.db, tmp, blt.file.mode); err != nil {
+		return nil, err
+	}
+
+	// the old handle is closed before the rename, the lock and the mmap of the file
+	// aren't shared between handles
+	if err := blt.file.db.Close(); err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return nil, err
+	}
+	renamed := os.Rename(tmp, blt.path)
+	if renamed != nil {
+		os.Remove(tmp) //nolint:errcheck
+	}
+	db, err := b.Open(blt.path, blt.file.mode, blt.file.options)
+	if err != nil {
+		// the closed bolt db returns b.ErrDatabaseNotOpen to the next calls
+		blt.opened = false
+		blt.hub.Close()
+		return nil, fmt.Errorf("on reopening compacted boltdb : %w", err)
+	}
+	blt.file.db = db
+	if renamed != nil {
+		return nil, renamed
+	}
+	if report.After, err = fileSize(blt.path); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// CompactFile rewrites the closed database file into a fresh file and swaps them
+// the fresh file has the mode of the current one
+func CompactFile(path string) (*dbtx.Compaction, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &dbtx.Compaction{Before: info.Size()}
+
+	src, err := b.Open(path, 0600, &b.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("on opening boltdb : %w", err)
+	}
+	tmp := path + ".compact"
+	err = compactTo(src, tmp, info.Mode().Perm())
+	if cerr := src.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp) //nolint:errcheck
+		return nil, err
+	}
+
+	if report.After, err = fileSize(path); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// compactTo copies all buckets of src, with their sequences, to a new file at path
+func compactTo(src *b.DB, path string, mode os.FileMode) error {
+	os.Remove(path) //nolint:errcheck
+	dst, err := b.Open(path, mode, nil)
+	if err != nil {
+		return err
+	}
+	err = src.View(func(tx *b.Tx) error {
+		return copyBuckets(dst, tx)
+	})
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path) //nolint:errcheck
+	}
+	return err
+}
+
+// copyBuckets writes the buckets of tx in dst, committing each compactTxSize bytes
+func copyBuckets(dst *b.DB, tx *b.Tx) error {
+	out, err := dst.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { out.Rollback() }() //nolint:errcheck
+
+	size := 0
+	err = tx.ForEach(func(name []byte, bkt *b.Bucket) error {
+		return walk(nil, name, bkt, func(path [][]byte, key, value []byte, seq uint64) error {
+			if size += len(key) + len(value); size > compactTxSize {
+				if err := out.Commit(); err != nil {
+					return err
+				}
+				next, err := dst.Begin(true)
+				if err != nil {
+					return err
+				}
+				out, size = next, len(key)+len(value)
+			}
+
+			// the buckets are created before their key/values
+			if len(path) == 0 {
+				created, err := out.CreateBucket(key)
+				if err != nil {
+					return err
+				}
+				return created.SetSequence(seq)
+			}
+			parent := out.Bucket(path[0])
+			for _, p := range path[1:] {
+				parent = parent.Bucket(p)
+			}
+			parent.FillPercent = 1
+			if value == nil {
+				created, err := parent.CreateBucket(key)
+				if err != nil {
+					return err
+				}
+				return created.SetSequence(seq)
+			}
+			return parent.Put(key, value)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return out.Commit()
+}
+
+// walk calls fn with the bucket and, recursively, its key/values and nested buckets
+// value is nil for the buckets, path has the names of the parent buckets
+func walk(path [][]byte, name []byte, bkt *b.Bucket, fn func(path [][]byte, key, value []byte, seq uint64) error) error {
+	if err := fn(path, name, nil, bkt.Sequence()); err != nil {
+		return err
+	}
+	path = append(append([][]byte{}, path...), name)
+	return bkt.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return walk(path, k, bkt.Bucket(k), fn)
+		}
+		return fn(path, k, v, 0)
+	})
+}
+
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -9,39 +9,92 @@ package bolt
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"time"
 
 	b "go.etcd.io/bbolt"
 	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/watch"
 )
 
 // Bolt with locked file with key/values
 // Path: of the main file.db which contains the key/values
 // The BoltDB work with focus in disk
 type Bolt struct {
-	db     *b.DB // database client
+	file   *file // database client, shared by the copies
 	tp     int
 	opened bool
 	path   string // database path inside the path
 	Bucket []byte // used for default or current bucket
+	hub    *watch.Hub
+}
+
+// DefaultFileMode of the files created by Open
+const DefaultFileMode os.FileMode = 0600
+
+// Options of OpenWith
+// ReadOnly: opens with a shared lock of the file, so many read-only handles can
+// read it, but it waits while the file is open for writes, the bucket must
+// exist and the writes return a *dbtx.ReadOnlyError
+// NoSync: the commits don't wait the fsync, the last ones may be lost in a crash
+// FileMode: mode of the file if it's created, DefaultFileMode if zero
+// Timeout: max time waiting the lock of the file, forever if zero, also in read-only mode
+type Options struct {
+	ReadOnly bool
+	NoSync   bool
+	FileMode os.FileMode
+	Timeout  time.Duration
 }
 
 // Open open file boltDB
 func Open(tp int, filepath string, bucket []byte) (*Bolt, error) {
-	db, err := b.Open(filepath, 0600, nil)
+	return OpenWith(tp, filepath, bucket, Options{})
+}
+
+// OpenWith opens the file boltDB with the options
+func OpenWith(tp int, filepath string, bucket []byte, opts Options) (*Bolt, error) {
+	mode := opts.FileMode
+	if mode == 0 {
+		mode = DefaultFileMode
+	}
+	options := &b.Options{ReadOnly: opts.ReadOnly, NoSync: opts.NoSync, Timeout: opts.Timeout}
+	db, err := b.Open(filepath, mode, options)
 	if err != nil {
 		return nil, fmt.Errorf("on opening boltdb : %s", err.Error())
 	}
 
 	boltdb := &Bolt{
-		db:     db,
+		file:   newFile(db, mode, options),
 		tp:     tp,
 		opened: true,
 		path:   filepath,
+		hub:    watch.NewHub(),
 	}
 
+	if opts.ReadOnly {
+		if err := boltdb.useBucket(bucket); err != nil {
+			db.Close() // nolint
+			return nil, err
+		}
+		return boltdb, nil
+	}
 	return boltdb, boltdb.CreateBuckets(bucket)
 }
 
+// useBucket sets the current bucket without creating it
+func (blt *Bolt) useBucket(bucket []byte) error {
+	if len(bucket) == 0 {
+		return nil
+	}
+	blt.Bucket = bucket
+	return blt.file.view(func(tx *b.Tx) error {
+		if tx.Bucket(bucket) == nil {
+			return fmt.Errorf("on opening boltdb : the bucket %q doesn't exist", bucket)
+		}
+		return nil
+	})
+}
+
 // Open returns true if the db is oppen
 func (blt *Bolt) Open() bool {
 	return blt.opened
@@ -64,7 +117,7 @@ func (blt *Bolt) CreateBuckets(buckets ...[]byte) error {
 			continue
 		}
 		blt.Bucket = bkt // the last is the current
-		err = blt.db.Update(func(tx *b.Tx) error {
+		err = blt.file.update(func(tx *b.Tx) error {
 			_, err := tx.CreateBucketIfNotExists(blt.Bucket)
 			return err
 		})
@@ -78,7 +131,7 @@ func (blt Bolt) DeleteBuckets(buckets ...[]byte) error {
 	var bucket []byte
 	for _, bkt := range buckets {
 		bucket = bkt
-		err = blt.db.Update(func(tx *b.Tx) error {
+		err = blt.file.update(func(tx *b.Tx) error {
 			err := tx.DeleteBucket(bucket)
 			if err != nil {
 				return err
@@ -92,7 +145,7 @@ func (blt Bolt) DeleteBuckets(buckets ...[]byte) error {
 // Size of database
 func (blt Bolt) Size() (int64, error) {
 	size := int64(0)
-	err := blt.db.View(func(tx *b.Tx) error {
+	err := blt.file.view(func(tx *b.Tx) error {
 		size = tx.Size()
 		return nil
 	})
@@ -100,16 +153,21 @@ func (blt Bolt) Size() (int64, error) {
 }
 
 // Clean bucket
+// the bucket is recreated empty, so it's still the current
 func (blt Bolt) Clean() {
-	blt.db.Update(func(tx *b.Tx) error { //nolint:errcheck
-		return tx.DeleteBucket(blt.Bucket)
+	blt.file.update(func(tx *b.Tx) error { //nolint:errcheck
+		if err := tx.DeleteBucket(blt.Bucket); err != nil && err != b.ErrBucketNotFound {
+			return err
+		}
+		_, err := tx.CreateBucket(blt.Bucket)
+		return err
 	})
 }
 
 // Length amount of keys in database
 func (blt Bolt) Length() int {
 	var len int
-	blt.db.View(func(tx *b.Tx) error { //nolint:errcheck
+	blt.file.view(func(tx *b.Tx) error { //nolint:errcheck
 		len = tx.Bucket(blt.Bucket).Stats().KeyN
 		return nil
 	})
@@ -122,14 +180,16 @@ func (blt Bolt) Path() string {
 }
 
 // Get from boltd
+// the value is copied, bolt values are only valid inside of the transaction
 func (blt Bolt) Get(key []byte) ([]byte, error) {
 	var value []byte
-	err := blt.db.View(func(tx *b.Tx) error {
+	err := blt.file.view(func(tx *b.Tx) error {
 		b := tx.Bucket(blt.Bucket)
-		value = b.Get(key)
-		if value == nil {
-			return fmt.Errorf("inexistent value")
+		v := b.Get(key)
+		if v == nil {
+			return dbtx.ErrNotFound
 		}
+		value = append([]byte{}, v...)
 		return nil
 	})
 	return value, err
@@ -137,49 +197,93 @@ func (blt Bolt) Get(key []byte) ([]byte, error) {
 
 // Upsert update or insert into boltdb
 func (blt Bolt) Upsert(key, value []byte) error {
-	return blt.db.Update(func(tx *b.Tx) error {
-		return tx.Bucket(blt.Bucket).Put(key, value)
+	return blt.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, value)
 	})
 }
 
 // Update updates all database executions inside one transaction
+// the writes are published to the watchers after the commit
 func (blt Bolt) Update(execute dbtx.Execute) error {
-	return blt.db.Update(func(tx *b.Tx) error {
-		return execute(tx.Bucket(blt.Bucket))
+	return blt.update(func(_ *b.Bucket, bkt dbtx.Bucket) error {
+		return execute(bkt)
+	})
+}
+
+// update runs the write inside one transaction and publishes it to the watchers
+// raw is the bolt bucket to read, the writes must be done in bkt to be published
+func (blt Bolt) update(write func(raw *b.Bucket, bkt dbtx.Bucket) error) error {
+	return blt.hub.Commit(func() ([]watch.Event, error) {
+		if !blt.hub.Watching() {
+			return nil, blt.file.update(func(tx *b.Tx) error {
+				raw := tx.Bucket(blt.Bucket)
+				return write(raw, raw)
+			})
+		}
+
+		var rec watch.Recorder
+		err := blt.file.update(func(tx *b.Tx) error {
+			raw := tx.Bucket(blt.Bucket)
+			return write(raw, dbtx.BucketImp{
+				PutImp: func(key []byte, val []byte) error {
+					if err := raw.Put(key, val); err != nil {
+						return err
+					}
+					rec.Put(key, val)
+					return nil
+				},
+				DeleteImp: func(key []byte) error {
+					if err := raw.Delete(key); err != nil {
+						return err
+					}
+					rec.Delete(key)
+					return nil
+				},
+			})
+		})
+		return rec.Events, err
 	})
 }
 
+// Watch returns the events of the keys with the prefix, committed after Watch returns
+// the slow subscribers are dropped, see the watch package
+func (blt Bolt) Watch(prefix []byte) (<-chan watch.Event, func()) {
+	return blt.hub.Watch(prefix)
+}
+
 // ForEach values from boltdb
 func (blt Bolt) ForEach(query func([]byte) error) error {
 	// its necessary for bolt queries
 	boltQuery := func(k, v []byte) error {
-		return query(v)
+		return query(append([]byte{}, v...))
 	}
-	return blt.db.View(func(tx *b.Tx) error {
+	return blt.file.view(func(tx *b.Tx) error {
 		return tx.Bucket(blt.Bucket).ForEach(boltQuery)
 	})
 }
 
 // Delete the key/value
 func (blt Bolt) Delete(key []byte) error {
-	return blt.db.Update(func(tx *b.Tx) error {
-		return tx.Bucket(blt.Bucket).Delete(key)
+	return blt.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Delete(key)
 	})
 }
 
 // Close and unlock the database
 // the db file or path are lock
+// the channels of the watchers are closed
 func (blt *Bolt) Close() error {
 	blt.opened = false
-	return blt.db.Close()
+	blt.hub.Close()
+	return blt.file.close()
 }
 
 // KeyIterator iterates only in keys
 func (blt Bolt) KeyIterator(query func([]byte) error) error {
 	boltQuery := func(k, v []byte) error {
-		return query(k)
+		return query(append([]byte{}, k...))
 	}
-	return blt.db.View(func(tx *b.Tx) error {
+	return blt.file.view(func(tx *b.Tx) error {
 		return tx.Bucket(blt.Bucket).ForEach(boltQuery)
 	})
 }
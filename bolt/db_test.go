@@ -2,9 +2,12 @@ package bolt_test
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/plateausnetwork/drivers/bolt"
+	"github.com/plateausnetwork/drivers/dbtx"
 	"github.com/plateausnetwork/drivers/runners"
 	"github.com/plateausnetwork/fs"
 )
@@ -192,3 +195,182 @@ func TestBuckets(t *testing.T) {
 		}
 	})
 }
+
+// the sequence continues after the reopen and doesn't change the key/values
+func TestSequence(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		path := fs.Path(dir).Join("test.db").String()
+		db, err := bolt.Open(tp, path, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq, err := db.Sequence([]byte("ids"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := seq.Next(); err != nil || n != 1 {
+			t.Errorf("expected the id 1, got %d %v", n, err)
+		}
+		if db.Length() != 0 {
+			t.Error("the sequence must not be a key of the bucket")
+		}
+		db.Close()
+
+		db, err = bolt.Open(tp, path, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		seq, err = db.Sequence([]byte("ids"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := seq.Next(); err != nil || n != 2 {
+			t.Errorf("expected the id 2, got %d %v", n, err)
+		}
+	})
+}
+
+// fill writes the key/values and deletes most of them, so the file has free pages
+func fill(t *testing.T, db *bolt.Bolt) {
+	for i := 0; i < 2000; i++ {
+		if err := db.Upsert([]byte(fmt.Sprintf("block/%05d", i)), bytes.Repeat(value, 200)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Upsert(key, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DeletePrefix([]byte("block/00")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// check the key/values kept by fill
+func check(t *testing.T, db *bolt.Bolt) {
+	if db.Length() != 1001 {
+		t.Errorf("expected 1001 keys, got %d", db.Length())
+	}
+	if v, err := db.Get([]byte("block/01999")); err != nil || !bytes.Equal(v, bytes.Repeat(value, 200)) {
+		t.Errorf("wrong value after the compaction %v", err)
+	}
+	if v, err := db.Get(key); err != nil || len(v) != 0 {
+		t.Errorf("the empty value must be kept %v", err)
+	}
+}
+
+// the compaction keeps the key/values and the sequences in a smaller file
+func TestCompact(t *testing.T) {
+	withBolt(func(db *bolt.Bolt) {
+		seq, err := db.Sequence([]byte("ids"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		seq.Next() // nolint
+		fill(t, db)
+
+		report, err := db.Compact()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Reclaimed() <= 0 {
+			t.Errorf("the compaction didn't reclaim space %+v", report)
+		}
+		check(t, db)
+		if n, err := seq.Next(); err != nil || n != 2 {
+			t.Errorf("expected the id 2, got %d %v", n, err)
+		}
+		if err := db.Upsert(key, value); err != nil {
+			t.Errorf("the database must be writable after the compaction %v", err)
+		}
+	})
+}
+
+// the calls inside of a transaction don't wait for a concurrent Compact
+func TestCompactNestedGet(t *testing.T) {
+	withBolt(func(db *bolt.Bolt) {
+		fill(t, db)
+		started := make(chan struct{})
+		updated, compacted := make(chan error, 1), make(chan error, 1)
+		go func() {
+			updated <- db.Update(func(bkt dbtx.Bucket) error {
+				close(started)
+				time.Sleep(50 * time.Millisecond) // Compact is waiting
+				_, err := db.Get(key)
+				return err
+			})
+		}()
+		<-started
+		go func() {
+			_, err := db.Compact()
+			compacted <- err
+		}()
+
+		for _, done := range []chan error{updated, compacted} {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the nested Get and Compact are deadlocked")
+			}
+		}
+		check(t, db)
+	})
+}
+
+func TestCompactFile(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		path := fs.Path(dir).Join("test.db").String()
+		db, err := bolt.Open(tp, path, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fill(t, db)
+		db.Close()
+
+		report, err := bolt.CompactFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Reclaimed() <= 0 {
+			t.Errorf("the compaction didn't reclaim space %+v", report)
+		}
+
+		db, err = bolt.Open(tp, path, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		check(t, db)
+	})
+}
+
+func TestVerify(t *testing.T) {
+	withBolt(func(db *bolt.Bolt) {
+		fill(t, db)
+		report, err := db.Verify()
+		if err != nil || !report.Healthy() || report.Keys != 1001 {
+			t.Errorf("wrong report of a healthy database %+v %v", report, err)
+		}
+	})
+}
+
+// the read-only mode waits the lock of the writer
+func TestReadOnlyLock(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		path := fs.Path(dir).Join("test.db").String()
+		db, err := bolt.Open(tp, path, testBucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		opts := bolt.Options{ReadOnly: true, Timeout: 50 * time.Millisecond}
+		if ro, err := bolt.OpenWith(tp, path, testBucket, opts); err == nil {
+			ro.Close()
+			t.Error("the read-only open must time out while the writer is open")
+		}
+	})
+}
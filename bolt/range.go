@@ -0,0 +1,51 @@
+package bolt
+
+import (
+	"bytes"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+	b "go.etcd.io/bbolt"
+)
+
+// DeletePrefix deletes the keys with the prefix, inside one transaction
+func (blt Bolt) DeletePrefix(prefix []byte) (int, error) {
+	return blt.DeleteRange(prefix, dbtx.PrefixEnd(prefix))
+}
+
+// DeleteRange deletes the keys from start until end (exclusive), inside one transaction
+// end nil deletes until the last key
+func (blt Bolt) DeleteRange(start, end []byte) (int, error) {
+	count := 0
+	err := blt.update(func(raw *b.Bucket, bkt dbtx.Bucket) error {
+		// the keys are deleted after the iteration, the cursor skips keys when deleting
+		var keys [][]byte
+		c := raw.Cursor()
+		for k, _ := c.Seek(start); k != nil && (end == nil || bytes.Compare(k, end) < 0); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountPrefix returns the amount of keys with the prefix
+func (blt Bolt) CountPrefix(prefix []byte) (int, error) {
+	count := 0
+	err := blt.file.view(func(tx *b.Tx) error {
+		c := tx.Bucket(blt.Bucket).Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			count++
+		}
+		return nil
+	})
+	return count, err
+}
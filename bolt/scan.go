@@ -0,0 +1,66 @@
+package bolt
+
+import (
+	"bytes"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+	b "go.etcd.io/bbolt"
+)
+
+// Scan returns a page of key/values of the bucket, see dbtx.ScanOptions
+func (blt Bolt) Scan(opts dbtx.ScanOptions) (*dbtx.Page, error) {
+	after, err := dbtx.DecodeToken(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	pager := dbtx.NewPager(opts)
+	err = blt.file.view(func(tx *b.Tx) error {
+		c := tx.Bucket(blt.Bucket).Cursor()
+
+		var k, v []byte
+		next := c.Next
+		if opts.Reverse {
+			k, v = seekLast(c, opts.Prefix, after)
+			next = c.Prev
+		} else {
+			k, v = seekFirst(c, opts.Prefix, after)
+		}
+
+		for ; k != nil && bytes.HasPrefix(k, opts.Prefix); k, v = next() {
+			if pager.Full() {
+				break
+			}
+			pager.Add(k, v)
+		}
+		return nil
+	})
+	return pager.Page(), err
+}
+
+// seekFirst returns the first key/value with the prefix, after the key of the token
+func seekFirst(c *b.Cursor, prefix, after []byte) ([]byte, []byte) {
+	if after == nil {
+		return c.Seek(prefix)
+	}
+	k, v := c.Seek(after)
+	if bytes.Equal(k, after) {
+		return c.Next()
+	}
+	return k, v
+}
+
+// seekLast returns the last key/value with the prefix, before the key of the token
+func seekLast(c *b.Cursor, prefix, before []byte) ([]byte, []byte) {
+	if before == nil {
+		before = dbtx.PrefixEnd(prefix)
+	}
+	if before == nil {
+		return c.Last()
+	}
+	// the first key after or at the bound, the previous is the last before it
+	if k, _ := c.Seek(before); k == nil {
+		return c.Last()
+	}
+	return c.Prev()
+}
@@ -0,0 +1,51 @@
+package bolt
+
+import (
+	"fmt"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+	b "go.etcd.io/bbolt"
+)
+
+// sequence of bolt, each id is committed in one transaction by Bucket.NextSequence
+type sequence struct {
+	file   *file
+	bucket []byte
+}
+
+// Sequence returns the sequence of the name
+// it uses the bucket of the name with dbtx.SequencePrefix, so it doesn't change the key/values
+func (blt Bolt) Sequence(name []byte) (dbtx.Sequence, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("bolt: empty sequence name")
+	}
+	seq := sequence{file: blt.file, bucket: append(append([]byte{}, dbtx.SequencePrefix...), name...)}
+	err := blt.file.update(func(tx *b.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(seq.bucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seq, nil
+}
+
+// Next id of the sequence
+func (seq sequence) Next() (uint64, error) {
+	var n uint64
+	err := seq.file.update(func(tx *b.Tx) error {
+		bkt := tx.Bucket(seq.bucket)
+		if bkt == nil {
+			return fmt.Errorf("bolt: the bucket of the sequence was deleted")
+		}
+		var err error
+		n, err = bkt.NextSequence()
+		return err
+	})
+	return n, err
+}
+
+// Release nothing, bolt doesn't lease ids
+func (seq sequence) Release() error {
+	return nil
+}
@@ -0,0 +1,44 @@
+package bolt
+
+import (
+	"github.com/plateausnetwork/drivers/dbtx"
+	b "go.etcd.io/bbolt"
+)
+
+// Verify reads all key/values of all buckets and checks the pages of the file with Tx.Check
+// Keys counts the key/values of all buckets
+// Tx.Check runs in its own goroutine, where the panics of the corrupted pages
+// can't be recovered, so it's skipped when the scan panics
+// the reads of pointers out of the mapped file are faults that crash the process
+func (blt Bolt) Verify() (*dbtx.Verification, error) {
+	report := &dbtx.Verification{}
+	err := blt.file.view(func(tx *b.Tx) error {
+		// a bucket that can't be read doesn't stop the scan of the others
+		err := tx.ForEach(func(name []byte, bkt *b.Bucket) error {
+			err := dbtx.Safely(func() error {
+				return walk(nil, name, bkt, func(_ [][]byte, _, value []byte, _ uint64) error {
+					if value != nil {
+						report.Keys++
+					}
+					return nil
+				})
+			})
+			if err != nil {
+				report.Add("scan", name, err)
+			}
+			return nil
+		})
+		if err != nil || !report.Healthy() {
+			return err
+		}
+
+		for err := range tx.Check() {
+			report.Add("check", nil, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
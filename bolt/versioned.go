@@ -0,0 +1,227 @@
+package bolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+	b "go.etcd.io/bbolt"
+)
+
+// flags of the versioned values
+const (
+	versionPut byte = iota
+	versionDelete
+)
+
+// Versioned is the bolt in versioned mode
+// each version is stored as: encoded key | height (big endian)
+// the key is escaped (0x00 -> 0x00 0xff) and ends with 0x00 0x01, so all
+// versions of a key are together and in ascending order of height
+// the value has a flag of put or delete before the value
+type Versioned struct {
+	blt *Bolt
+}
+
+// OpenVersioned open file boltDB in versioned mode
+func OpenVersioned(tp int, filepath string, bucket []byte) (*Versioned, error) {
+	blt, err := Open(tp, filepath, bucket)
+	if err != nil {
+		return nil, err
+	}
+	return &Versioned{blt: blt}, nil
+}
+
+// encodeKey escapes the key, the result has no other key as prefix
+func encodeKey(key []byte) []byte {
+	enc := make([]byte, 0, len(key)+2)
+	for _, c := range key {
+		enc = append(enc, c)
+		if c == 0 {
+			enc = append(enc, 0xff)
+		}
+	}
+	return append(enc, 0, 1)
+}
+
+// decodeKey returns the key and the height of the stored key
+func decodeKey(stored []byte) ([]byte, uint64, error) {
+	key := []byte{}
+	for i := 0; i < len(stored)-1; i++ {
+		if stored[i] != 0 {
+			key = append(key, stored[i])
+			continue
+		}
+		i++
+		switch stored[i] {
+		case 0xff:
+			key = append(key, 0)
+		case 1:
+			if len(stored)-i-1 != 8 {
+				return nil, 0, fmt.Errorf("bolt: invalid versioned key")
+			}
+			return key, binary.BigEndian.Uint64(stored[i+1:]), nil
+		default:
+			return nil, 0, fmt.Errorf("bolt: invalid versioned key")
+		}
+	}
+	return nil, 0, fmt.Errorf("bolt: invalid versioned key")
+}
+
+func versionKey(key []byte, height uint64) []byte {
+	enc := encodeKey(key)
+	var h [8]byte
+	binary.BigEndian.PutUint64(h[:], height)
+	return append(enc, h[:]...)
+}
+
+// Type setted by caller
+func (v *Versioned) Type() int {
+	return v.blt.Type()
+}
+
+// Path returns the full path
+func (v *Versioned) Path() string {
+	return v.blt.Path()
+}
+
+// Close and unlock the database
+func (v *Versioned) Close() error {
+	return v.blt.Close()
+}
+
+// UpsertAt update or insert the key/value at the height
+func (v *Versioned) UpsertAt(height uint64, key, value []byte) error {
+	return v.UpdateAt(height, func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, value)
+	})
+}
+
+// DeleteAt deletes the key/value at the height
+func (v *Versioned) DeleteAt(height uint64, key []byte) error {
+	return v.UpdateAt(height, func(bkt dbtx.Bucket) error {
+		return bkt.Delete(key)
+	})
+}
+
+// UpdateAt updates all executions inside one transaction at the height
+func (v *Versioned) UpdateAt(height uint64, execute dbtx.Execute) error {
+	return v.blt.file.update(func(tx *b.Tx) error {
+		bkt := tx.Bucket(v.blt.Bucket)
+		return execute(dbtx.BucketImp{
+			PutImp: func(key []byte, val []byte) error {
+				return bkt.Put(versionKey(key, height), append([]byte{versionPut}, val...))
+			},
+			DeleteImp: func(key []byte) error {
+				return bkt.Put(versionKey(key, height), []byte{versionDelete})
+			},
+		})
+	})
+}
+
+// GetAt the value of the key at the height
+func (v *Versioned) GetAt(key []byte, height uint64) ([]byte, error) {
+	var value []byte
+	err := v.blt.file.view(func(tx *b.Tx) error {
+		c := tx.Bucket(v.blt.Bucket).Cursor()
+		target := versionKey(key, height)
+
+		// the last version before or at the height
+		k, val := c.Seek(target)
+		if k == nil {
+			k, val = c.Last()
+		} else if !bytes.Equal(k, target) {
+			k, val = c.Prev()
+		}
+
+		if k == nil || !bytes.HasPrefix(k, encodeKey(key)) || val[0] == versionDelete {
+			return dbtx.ErrNotFound
+		}
+		value = append([]byte{}, val[1:]...)
+		return nil
+	})
+	return value, err
+}
+
+// ForEachAt key/value at the height
+func (v *Versioned) ForEachAt(height uint64, query func(key, value []byte) error) error {
+	return v.blt.file.view(func(tx *b.Tx) error {
+		var current, value []byte
+		found := false
+
+		// the versions are in ascending order, the last one until the height is the value
+		flush := func() error {
+			if found && value[0] == versionPut {
+				return query(current, append([]byte{}, value[1:]...))
+			}
+			return nil
+		}
+
+		c := tx.Bucket(v.blt.Bucket).Cursor()
+		for k, val := c.First(); k != nil; k, val = c.Next() {
+			key, h, err := decodeKey(k)
+			if err != nil {
+				return err
+			}
+
+			if !bytes.Equal(key, current) || current == nil {
+				if err := flush(); err != nil {
+					return err
+				}
+				current, found = key, false
+			}
+
+			if h <= height {
+				value, found = val, true
+			}
+		}
+		return flush()
+	})
+}
+
+// Prune removes the versions not needed to read at the height or later
+// for each key, it keeps the last version before or at the height
+// if it isn't a delete
+func (v *Versioned) Prune(height uint64) error {
+	return v.blt.file.update(func(tx *b.Tx) error {
+		bkt := tx.Bucket(v.blt.Bucket)
+
+		var obsolete [][]byte
+		var current, last, lastValue []byte
+		err := bkt.ForEach(func(k, val []byte) error {
+			key, h, err := decodeKey(k)
+			if err != nil {
+				return err
+			}
+
+			if !bytes.Equal(key, current) || current == nil {
+				if last != nil && lastValue[0] == versionDelete {
+					obsolete = append(obsolete, last)
+				}
+				current, last = key, nil
+			}
+
+			if h <= height {
+				if last != nil {
+					obsolete = append(obsolete, last)
+				}
+				last, lastValue = k, val
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if last != nil && lastValue[0] == versionDelete {
+			obsolete = append(obsolete, last)
+		}
+
+		for _, k := range obsolete {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,120 @@
+package bolt_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/plateausnetwork/drivers/bolt"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/runners"
+	"github.com/plateausnetwork/fs"
+)
+
+func withVersioned(handler func(*bolt.Versioned)) {
+	runners.WithTempDir(func(dir string) {
+		db, err := bolt.OpenVersioned(tp, fs.Path(dir).Join("test.db").String(), testBucket)
+		if err != nil {
+			panic(err)
+		}
+
+		defer db.Close()
+		handler(db)
+	})
+}
+
+func TestVersions(t *testing.T) {
+	withVersioned(func(db *bolt.Versioned) {
+		// key "k" and keys with it as prefix
+		for h, v := range []string{"v0", "v1", "v2"} {
+			if err := db.UpsertAt(uint64(h*10), key, []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.UpsertAt(5, append(key, 0), []byte("other")); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteAt(30, key); err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[uint64]string{0: "v0", 9: "v0", 10: "v1", 25: "v2"}
+		for h, v := range expected {
+			got, err := db.GetAt(key, h)
+			if err != nil || !bytes.Equal(got, []byte(v)) {
+				t.Errorf("height %d: expected %s, received %s %v", h, v, got, err)
+			}
+		}
+
+		for _, h := range []uint64{30, 100} {
+			if _, err := db.GetAt(key, h); !errors.Is(err, dbtx.ErrNotFound) {
+				t.Errorf("height %d: the key must be deleted: %v", h, err)
+			}
+		}
+
+		var keys [][]byte
+		if err := db.ForEachAt(10, func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || !bytes.Equal(keys[0], key) || !bytes.Equal(keys[1], append(key, 0)) {
+			t.Errorf("invalid keys at height 10: %q", keys)
+		}
+	})
+}
+
+func TestPrune(t *testing.T) {
+	withVersioned(func(db *bolt.Versioned) {
+		for h := uint64(0); h < 5; h++ {
+			if err := db.UpsertAt(h, key, []byte{byte(h)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.UpsertAt(0, []byte("deleted"), value); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteAt(1, []byte("deleted")); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Prune(2); err != nil {
+			t.Fatal(err)
+		}
+
+		for h := uint64(2); h < 5; h++ {
+			if v, err := db.GetAt(key, h); err != nil || v[0] != byte(h) {
+				t.Errorf("height %d must be available after prune: %v", h, err)
+			}
+		}
+
+		if _, err := db.GetAt(key, 1); err == nil {
+			t.Error("height 1 must be pruned")
+		}
+
+		if _, err := db.GetAt([]byte("deleted"), 0); err == nil {
+			t.Error("the deleted key must be pruned")
+		}
+	})
+}
+
+func TestUpdateAt(t *testing.T) {
+	withVersioned(func(db *bolt.Versioned) {
+		if err := db.UpsertAt(1, key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		err := db.UpdateAt(2, func(bkt dbtx.Bucket) error {
+			bkt.Delete(key) // nolint
+			return errors.New("test error")
+		})
+		if err == nil {
+			t.Error("the error of the execution must be returned")
+		}
+
+		if v, err := db.GetAt(key, 2); err != nil || !bytes.Equal(v, value) {
+			t.Error("the failed transaction must not change the versions")
+		}
+	})
+}
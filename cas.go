@@ -0,0 +1,97 @@
+package drivers
+
+import (
+	"errors"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Transform of the keys or values stored by a wrapper of KeyValueDB, like the compression
+type Transform func([]byte) ([]byte, error)
+
+// Stored has the transforms of a wrapper, used by its conditional writes
+// Key: returns the stored key, nil if the key isn't changed
+// Decode and Encode: transform the stored values
+// the stored value is decoded and compared with the expected, the new value is
+// encoded and swapped by the CompareAndSwap of the wrapped database, which is
+// retried if the stored value changes
+type Stored struct {
+	Key    func([]byte) []byte
+	Decode Transform
+	Encode Transform
+}
+
+func (s Stored) key(key []byte) []byte {
+	if s.Key == nil {
+		return key
+	}
+	return s.Key(key)
+}
+
+// load returns the stored value and the decoded one, found is false if the key doesn't exist
+func (s Stored) load(db KeyValueDB, key []byte) (stored, decoded []byte, found bool, err error) {
+	stored, err = db.Get(s.key(key))
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if stored == nil {
+		stored = []byte{} // nil is the expected value of a key that doesn't exist
+	}
+	decoded, err = s.Decode(stored)
+	return stored, decoded, err == nil, err
+}
+
+// CompareAndSwap of the wrapper over the wrapped database
+func (s Stored) CompareAndSwap(db KeyValueDB, key, expected, value []byte) error {
+	var encoded []byte
+	if value != nil {
+		var err error
+		if encoded, err = s.Encode(value); err != nil {
+			return err
+		}
+	}
+
+	for {
+		stored, decoded, found, err := s.load(db, key)
+		if err != nil {
+			return err
+		}
+		if err := dbtx.CheckSwap(key, decoded, found, expected); err != nil {
+			return err
+		}
+
+		err = db.CompareAndSwap(s.key(key), stored, encoded)
+		if !errors.Is(err, ErrPrecondition) {
+			return err
+		}
+	}
+}
+
+// Increment of the wrapper over the wrapped database
+func (s Stored) Increment(db KeyValueDB, key []byte, delta int64) (int64, error) {
+	for {
+		stored, decoded, found, err := s.load(db, key)
+		if err != nil {
+			return 0, err
+		}
+		n, err := dbtx.AddCounter(key, decoded, found, delta)
+		if err != nil {
+			return 0, err
+		}
+		encoded, err := s.Encode(dbtx.EncodeCounter(n))
+		if err != nil {
+			return 0, err
+		}
+
+		err = db.CompareAndSwap(s.key(key), stored, encoded)
+		if err == nil {
+			return n, nil
+		}
+		if !errors.Is(err, ErrPrecondition) {
+			return 0, err
+		}
+	}
+}
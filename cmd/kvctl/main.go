@@ -0,0 +1,129 @@
+/*
+	Command kvctl checks and repairs the databases of the drivers.
+
+	Usage:
+		kvctl check [-driver bolt|badger] [-bucket rhz] PATH
+		kvctl repair [-driver bolt|badger] [-bucket rhz] SRC DST
+
+	check verifies the database and prints the problems found.
+	repair copies the readable key/values of SRC to the new database DST,
+	for bolt only the key/values of the bucket are copied.
+	The database checked and SRC are open in read-only mode, they must exist.
+	The exit status is 0 if no problem was found, 1 if there are problems
+	and 2 if the command failed.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/plateausnetwork/drivers"
+)
+
+// exit status
+const (
+	healthy = iota
+	damaged
+	failed
+)
+
+var driverTypes = map[string]drivers.DriverType{
+	"bolt":   drivers.Boltdb,
+	"badger": drivers.Badgerdb,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: kvctl check|repair [flags] PATH [DST]")
+		return failed
+	}
+
+	flags := flag.NewFlagSet("kvctl "+args[0], flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	driver := flags.String("driver", "bolt", "driver of the database: bolt or badger")
+	bucket := flags.String("bucket", string(drivers.DefaultOptions.Bucket), "bucket of the bolt database")
+	if err := flags.Parse(args[1:]); err != nil {
+		return failed
+	}
+	dtp, ok := driverTypes[*driver]
+	if !ok {
+		fmt.Fprintf(stderr, "kvctl: unknown driver %q\n", *driver)
+		return failed
+	}
+	opts := drivers.Options{Bucket: []byte(*bucket)}
+
+	var report *drivers.Verification
+	var err error
+	switch {
+	case args[0] == "check" && flags.NArg() == 1:
+		report, err = check(dtp, flags.Arg(0), opts)
+	case args[0] == "repair" && flags.NArg() == 2:
+		report, err = repair(dtp, flags.Arg(0), flags.Arg(1), opts)
+	default:
+		fmt.Fprintln(stderr, "usage: kvctl check|repair [flags] PATH [DST]")
+		return failed
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "kvctl: %v\n", err)
+		return failed
+	}
+
+	fmt.Fprintf(stdout, "%d key/values read\n", report.Keys)
+	for _, p := range report.Problems {
+		fmt.Fprintln(stdout, p)
+	}
+	if !report.Healthy() {
+		fmt.Fprintf(stdout, "%d problems found\n", len(report.Problems))
+		return damaged
+	}
+	return healthy
+}
+
+// openSource opens the existing database in read-only mode, so it isn't changed
+func openSource(dtp drivers.DriverType, path string, opts drivers.Options) (drivers.KeyValueDB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	opts.ReadOnly = true
+	return drivers.Open(dtp, path, opts)
+}
+
+func check(dtp drivers.DriverType, path string, opts drivers.Options) (*drivers.Verification, error) {
+	db, err := openSource(dtp, path, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	return drivers.Verify(db)
+}
+
+// repair never writes in an existing database
+func repair(dtp drivers.DriverType, src, dst string, opts drivers.Options) (*drivers.Verification, error) {
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("the destination %s already exists", dst)
+	}
+
+	from, err := openSource(dtp, src, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer from.Close()
+	to, err := drivers.Open(dtp, dst, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := drivers.Salvage(from, to)
+	if cerr := to.Close(); err == nil {
+		err = cerr
+	}
+	return report, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+func TestCheckRepair(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		src, dst := dir+"/src.db", dir+"/dst.db"
+		db, err := drivers.Open(drivers.Boltdb, src, drivers.DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Upsert([]byte("key"), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		var out bytes.Buffer
+		if code := run([]string{"check", src}, &out, &out); code != healthy {
+			t.Errorf("expected a healthy database, got %d: %s", code, out.String())
+		}
+		if code := run([]string{"repair", "-bucket", "rhz", src, dst}, &out, &out); code != healthy {
+			t.Errorf("expected a healthy repair, got %d: %s", code, out.String())
+		}
+		if code := run([]string{"repair", src, dst}, &out, &out); code != failed {
+			t.Error("the repair must not write in an existing database")
+		}
+		if code := run([]string{"check", "-driver", "leveldb", src}, &out, &out); code != failed {
+			t.Error("the driver must be validated")
+		}
+		if code := run([]string{"check", dir + "/missing.db"}, &out, &out); code != failed {
+			t.Error("the database checked must exist")
+		}
+		if _, err := os.Stat(dir + "/missing.db"); !os.IsNotExist(err) {
+			t.Errorf("the check must not create the database: %v", err)
+		}
+		if code := run([]string{"check", "-bucket", "other", src}, &out, &out); code != failed {
+			t.Error("the check must not create the bucket")
+		}
+
+		db, err = drivers.Open(drivers.Boltdb, dst, drivers.DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if v, err := db.Get([]byte("key")); err != nil || string(v) != "value" {
+			t.Errorf("the key/value wasn't repaired %v", err)
+		}
+	})
+}
@@ -0,0 +1,283 @@
+/*
+	Package compression implements a drivers.KeyValueDB that compresses the values
+	of any other driver, the keys are not changed.
+	Each value has a header with a magic and the codec byte, values without
+	the header are legacy values and are returned as they are.
+*/
+
+package compression
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// codecs of the values
+const (
+	None byte = iota
+	Flate
+	Gzip
+)
+
+// magic is the start of the header of the values
+// a legacy value starting with the magic and a valid codec can't be read
+var magic = []byte{0xc0, 0x4d}
+
+// header length: magic + codec
+const headerLen = 3
+
+// DefaultOptions compresses with flate the values bigger than 512 bytes
+var DefaultOptions = Options{
+	Codec:     Flate,
+	Threshold: 512,
+	Level:     flate.DefaultCompression,
+}
+
+// Options of the compression
+// Codec: used in new values
+// Threshold: values smaller than it are stored without compression
+// Level: compression level of flate and gzip, flate.DefaultCompression if zero
+type Options struct {
+	Codec     byte
+	Threshold int
+	Level     int
+}
+
+// Stats of the written values
+// RawBytes: size of the values before the compression
+// StoredBytes: size of the values written in the database, with headers
+type Stats struct {
+	Writes      int64
+	Compressed  int64
+	RawBytes    int64
+	StoredBytes int64
+}
+
+// Ratio of the stored bytes by the raw bytes, 1 if nothing was written
+func (s Stats) Ratio() float64 {
+	if s.RawBytes == 0 {
+		return 1
+	}
+	return float64(s.StoredBytes) / float64(s.RawBytes)
+}
+
+func (s *Stats) add(other Stats) {
+	atomic.AddInt64(&s.Writes, other.Writes)
+	atomic.AddInt64(&s.Compressed, other.Compressed)
+	atomic.AddInt64(&s.RawBytes, other.RawBytes)
+	atomic.AddInt64(&s.StoredBytes, other.StoredBytes)
+}
+
+// DB compresses the values of the wrapped database
+type DB struct {
+	drivers.KeyValueDB
+	opts  Options
+	stats Stats
+}
+
+// New wraps the database
+func New(db drivers.KeyValueDB, opts Options) (*DB, error) {
+	if opts.Codec > Gzip {
+		return nil, fmt.Errorf("compression: invalid codec %d", opts.Codec)
+	}
+	// the zero value is flate.NoCompression, the Codec None doesn't compress
+	if opts.Level == 0 {
+		opts.Level = flate.DefaultCompression
+	}
+	return &DB{KeyValueDB: db, opts: opts}, nil
+}
+
+// Stats returns the stats of the values written since New
+func (db *DB) Stats() Stats {
+	return Stats{
+		Writes:      atomic.LoadInt64(&db.stats.Writes),
+		Compressed:  atomic.LoadInt64(&db.stats.Compressed),
+		RawBytes:    atomic.LoadInt64(&db.stats.RawBytes),
+		StoredBytes: atomic.LoadInt64(&db.stats.StoredBytes),
+	}
+}
+
+// encode the value with the header
+// the value is stored without compression if it's smaller or if the compression doesn't help
+func (db *DB) encode(value []byte, stats *Stats) ([]byte, error) {
+	codec := db.opts.Codec
+	if len(value) < db.opts.Threshold {
+		codec = None
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, headerLen+len(value)))
+	buf.Write(magic)
+	buf.WriteByte(codec)
+
+	var w io.WriteCloser
+	var err error
+	switch codec {
+	case Flate:
+		w, err = flate.NewWriter(buf, db.opts.Level)
+	case Gzip:
+		w, err = gzip.NewWriterLevel(buf, db.opts.Level)
+	default:
+		buf.Write(value)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if w != nil {
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+
+		if buf.Len() >= headerLen+len(value) {
+			// compression doesn't help, stores the raw value
+			buf.Reset()
+			buf.Write(magic)
+			buf.WriteByte(None)
+			buf.Write(value)
+		} else {
+			stats.Compressed++
+		}
+	}
+
+	stats.Writes++
+	stats.RawBytes += int64(len(value))
+	stats.StoredBytes += int64(buf.Len())
+	return buf.Bytes(), nil
+}
+
+// decode the stored value
+func decode(stored []byte) ([]byte, error) {
+	if len(stored) < headerLen || !bytes.Equal(stored[:len(magic)], magic) {
+		return stored, nil // legacy value
+	}
+
+	data := stored[headerLen:]
+	var r io.ReadCloser
+	var err error
+	switch stored[len(magic)] {
+	case None:
+		return data, nil
+	case Flate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	default:
+		return stored, nil // legacy value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("compression: %s", err.Error())
+	}
+	defer r.Close()
+
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("compression: %s", err.Error())
+	}
+	return value, nil
+}
+
+// Get decodes the value
+func (db *DB) Get(key []byte) ([]byte, error) {
+	stored, err := db.KeyValueDB.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decode(stored)
+}
+
+// ForEach decodes the values before the query
+func (db *DB) ForEach(query func([]byte) error) error {
+	return db.KeyValueDB.ForEach(func(stored []byte) error {
+		value, err := decode(stored)
+		if err != nil {
+			return err
+		}
+		return query(value)
+	})
+}
+
+// Upsert encodes the value
+func (db *DB) Upsert(key, value []byte) error {
+	var stats Stats
+	stored, err := db.encode(value, &stats)
+	if err != nil {
+		return err
+	}
+	if err := db.KeyValueDB.Upsert(key, stored); err != nil {
+		return err
+	}
+	db.stats.add(stats)
+	return nil
+}
+
+// Update encodes the values of the bucket
+// the stats are changed only if the transaction is committed
+func (db *DB) Update(execute dbtx.Execute) error {
+	var stats Stats
+	err := db.KeyValueDB.Update(func(bkt dbtx.Bucket) error {
+		stats = Stats{} // the transaction can be retried
+		return execute(dbtx.BucketImp{
+			PutImp: func(key []byte, value []byte) error {
+				stored, err := db.encode(value, &stats)
+				if err != nil {
+					return err
+				}
+				return bkt.Put(key, stored)
+			},
+			DeleteImp: bkt.Delete,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	db.stats.add(stats)
+	return nil
+}
+
+// stored transforms of the conditional writes, the stats are counted by commit
+func (db *DB) stored(stats *Stats) drivers.Stored {
+	return drivers.Stored{
+		Decode: decode,
+		Encode: func(value []byte) ([]byte, error) {
+			*stats = Stats{} // the write can be retried
+			return db.encode(value, stats)
+		},
+	}
+}
+
+// CompareAndSwap compares the decoded value and swaps the encoded one
+func (db *DB) CompareAndSwap(key, expected, value []byte) error {
+	var stats Stats
+	if err := db.stored(&stats).CompareAndSwap(db.KeyValueDB, key, expected, value); err != nil {
+		return err
+	}
+	db.stats.add(stats)
+	return nil
+}
+
+// PutIfAbsent encodes the value if the key doesn't exist
+func (db *DB) PutIfAbsent(key, value []byte) error {
+	return db.CompareAndSwap(key, nil, value)
+}
+
+// Increment the decoded counter, the counters are smaller than the threshold
+func (db *DB) Increment(key []byte, delta int64) (int64, error) {
+	var stats Stats
+	n, err := db.stored(&stats).Increment(db.KeyValueDB, key, delta)
+	if err != nil {
+		return 0, err
+	}
+	db.stats.add(stats)
+	return n, nil
+}
@@ -0,0 +1,161 @@
+package compression_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/compression"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/drivertest"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+var key = []byte("key")
+var value = bytes.Repeat([]byte("block body "), 100)
+var testBucket = []byte("tbucket")
+
+func openBolt(dir string) (drivers.KeyValueDB, error) {
+	opts := drivers.DriverOptions()
+	opts.AddBucket(testBucket)
+	return drivers.Open(drivers.Boltdb, dir+"/test.db", opts)
+}
+
+func withCompression(opts compression.Options, handler func(*compression.DB)) {
+	runners.WithTempDir(func(dir string) {
+		db, err := openBolt(dir)
+		if err != nil {
+			panic(err)
+		}
+		defer db.Close()
+
+		cdb, err := compression.New(db, opts)
+		if err != nil {
+			panic(err)
+		}
+		handler(cdb)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	for _, codec := range []byte{compression.None, compression.Flate, compression.Gzip} {
+		opts := compression.DefaultOptions
+		opts.Codec = codec
+		opts.Threshold = 0
+		drivertest.RunConformance(t, func(dir string) (drivers.KeyValueDB, error) {
+			db, err := openBolt(dir)
+			if err != nil {
+				return nil, err
+			}
+			return compression.New(db, opts)
+		}, drivertest.Options{Buckets: true})
+	}
+}
+
+func TestCompression(t *testing.T) {
+	withCompression(compression.DefaultOptions, func(db *compression.DB) {
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := db.KeyValueDB.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stored) >= len(value) {
+			t.Error("the stored value must be compressed")
+		}
+
+		v, err := db.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, value) {
+			t.Error("the value is different than expected")
+		}
+
+		stats := db.Stats()
+		if stats.Writes != 1 || stats.Compressed != 1 || stats.Ratio() >= 1 {
+			t.Errorf("invalid stats %+v", stats)
+		}
+	})
+}
+
+func TestDefaultLevel(t *testing.T) {
+	opts := compression.Options{Codec: compression.Flate, Threshold: 256}
+	withCompression(opts, func(db *compression.DB) {
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if stats := db.Stats(); stats.Compressed != 1 || stats.Ratio() >= 0.5 {
+			t.Errorf("the zero level must compress with the default level %+v", stats)
+		}
+	})
+}
+
+func TestThreshold(t *testing.T) {
+	withCompression(compression.DefaultOptions, func(db *compression.DB) {
+		small := []byte("small")
+		if err := db.Upsert(key, small); err != nil {
+			t.Fatal(err)
+		}
+
+		if stats := db.Stats(); stats.Compressed != 0 {
+			t.Error("values smaller than the threshold must not be compressed")
+		}
+
+		if v, err := db.Get(key); err != nil || !bytes.Equal(v, small) {
+			t.Error("the value is different than expected")
+		}
+	})
+}
+
+func TestLegacyValues(t *testing.T) {
+	withCompression(compression.DefaultOptions, func(db *compression.DB) {
+		// written without the wrapper
+		if err := db.KeyValueDB.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		v, err := db.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(v, value) {
+			t.Error("legacy values must be returned as they are")
+		}
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	withCompression(compression.DefaultOptions, func(db *compression.DB) {
+		err := db.Update(func(bkt dbtx.Bucket) error {
+			return bkt.Put(key, value)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var values [][]byte
+		if err := db.ForEach(func(v []byte) error {
+			values = append(values, v)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(values) != 1 || !bytes.Equal(values[0], value) {
+			t.Error("the values written by Update must be compressed")
+		}
+
+		if stats := db.Stats(); stats.Compressed != 1 {
+			t.Errorf("invalid stats %+v", stats)
+		}
+	})
+}
+
+func TestInvalidCodec(t *testing.T) {
+	if _, err := compression.New(nil, compression.Options{Codec: 99}); err == nil {
+		t.Error("invalid codec must return an error")
+	}
+}
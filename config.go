@@ -0,0 +1,169 @@
+package drivers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Config of the named stores, like:
+//
+//	{"stores": {
+//	  "blocks": {"driver": "bolt", "path": "/var/data/blocks.db", "buckets": ["headers"],
+//	             "options": {"bucket": "rhz", "timeout": "5s"}},
+//	  "cache": {"driver": "ristretto", "path": "cache", "options": {"maxcost": 1e8}}
+//	}}
+//
+// the options are the parameters of the URLs, see URL
+type Config struct {
+	Stores map[string]StoreConfig `json:"stores"`
+}
+
+// StoreConfig of a store
+// Buckets: created on open, the bucket of the options is still the current
+type StoreConfig struct {
+	Driver  string                     `json:"driver"`
+	Path    string                     `json:"path"`
+	Options map[string]json.RawMessage `json:"options"`
+	Buckets []string                   `json:"buckets"`
+}
+
+// LoadStores reads the config file and opens its stores, see Config.Open
+func LoadStores(path string) (*Stores, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config, err := ReadConfig(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config.Open()
+}
+
+// ReadConfig decodes the JSON config, the unknown fields are errors
+func ReadConfig(r io.Reader) (*Config, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	config := &Config{}
+	if err := dec.Decode(config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// url returns the URL of the store
+func (sc StoreConfig) url() (*URL, error) {
+	u, err := newURL(sc.Driver)
+	if err != nil {
+		var urlErr *URLError
+		if errors.As(err, &urlErr) {
+			urlErr.Param = "driver"
+		}
+		return nil, err
+	}
+	u.Path = sc.Path
+
+	// the values are strings or the JSON of numbers and booleans, like 1e8 and true
+	for name, raw := range sc.Options {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = strings.TrimSpace(string(raw))
+		}
+		if err := u.setParam(name, value); err != nil {
+			return nil, err
+		}
+	}
+	if len(sc.Buckets) > 0 && u.Driver == Boltdb && u.Options.ReadOnly {
+		return nil, &URLError{Param: "buckets", Value: strings.Join(sc.Buckets, ","), Err: errors.New("can't be created in read-only mode")}
+	}
+	return u, u.validate()
+}
+
+// Open all stores, in the order of their names
+// the config is validated before opening any store, and the stores already
+// open are closed if one fails
+// the stores are open by OpenShared, so the stores of the same path are shared
+func (c *Config) Open() (*Stores, error) {
+	if len(c.Stores) == 0 {
+		return nil, errors.New("the config has no stores")
+	}
+	names := make([]string, 0, len(c.Stores))
+	urls := make(map[string]*URL, len(c.Stores))
+	for name, sc := range c.Stores {
+		u, err := sc.url()
+		if err != nil {
+			return nil, fmt.Errorf("store %q: %w", name, err)
+		}
+		names = append(names, name)
+		urls[name] = u
+	}
+	sort.Strings(names)
+
+	s := &Stores{dbs: make(map[string]KeyValueDB, len(names))}
+	for _, name := range names {
+		db, err := s.open(urls[name], c.Stores[name].Buckets)
+		if err != nil {
+			s.Close() // nolint
+			return nil, fmt.Errorf("store %q: %w", name, err)
+		}
+		s.names = append(s.names, name)
+		s.dbs[name] = db
+	}
+	return s, nil
+}
+
+// Stores open by a config
+type Stores struct {
+	names []string // in the order of opening
+	dbs   map[string]KeyValueDB
+}
+
+func (s *Stores) open(u *URL, buckets []string) (KeyValueDB, error) {
+	db, err := OpenShared(u.Driver, u.Path, u.Options)
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets) == 0 {
+		return db, nil
+	}
+
+	// the last bucket created is the current one
+	list := make([][]byte, 0, len(buckets)+1)
+	for _, bkt := range buckets {
+		list = append(list, []byte(bkt))
+	}
+	if err := db.CreateBuckets(append(list, u.Options.Bucket)...); err != nil {
+		db.Close() // nolint
+		return nil, err
+	}
+	return db, nil
+}
+
+// Get returns the store of the name and false if it isn't in the config
+func (s *Stores) Get(name string) (KeyValueDB, bool) {
+	db, ok := s.dbs[name]
+	return db, ok
+}
+
+// Names of the stores in the order of opening
+func (s *Stores) Names() []string {
+	return append([]string{}, s.names...)
+}
+
+// Close all stores in the reverse order of opening, the first error is returned
+func (s *Stores) Close() error {
+	var first error
+	for i := len(s.names) - 1; i >= 0; i-- {
+		if err := s.dbs[s.names[i]].Close(); err != nil && first == nil {
+			first = fmt.Errorf("store %q: %w", s.names[i], err)
+		}
+	}
+	s.names, s.dbs = nil, map[string]KeyValueDB{}
+	return first
+}
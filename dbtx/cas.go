@@ -0,0 +1,86 @@
+package dbtx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPrecondition is matched by the PreconditionError of the conditional writes
+	ErrPrecondition = errors.New("precondition failed")
+	// ErrInvalidCounter is returned by Increment when the value isn't a counter
+	ErrInvalidCounter = errors.New("the value isn't a counter")
+)
+
+// PreconditionError is returned when the value of the key isn't the expected one
+// Expected nil means that the key must not exist, Found is false if it doesn't exist
+type PreconditionError struct {
+	Key      []byte
+	Expected []byte
+	Actual   []byte
+	Found    bool
+}
+
+func (e *PreconditionError) Error() string {
+	switch {
+	case e.Expected == nil:
+		return fmt.Sprintf("%v: the key %q exists", ErrPrecondition, e.Key)
+	case !e.Found:
+		return fmt.Sprintf("%v: the key %q doesn't exist", ErrPrecondition, e.Key)
+	}
+	return fmt.Sprintf("%v: the value of the key %q isn't the expected one", ErrPrecondition, e.Key)
+}
+
+// Is matches ErrPrecondition
+func (e *PreconditionError) Is(target error) bool {
+	return target == ErrPrecondition
+}
+
+// CheckSwap returns a PreconditionError if the current value isn't the expected one
+// found is false if the key doesn't exist, expected nil means that it must not exist
+func CheckSwap(key, current []byte, found bool, expected []byte) error {
+	if expected == nil && !found {
+		return nil
+	}
+	if expected != nil && found && bytes.Equal(current, expected) {
+		return nil
+	}
+	err := &PreconditionError{Key: append([]byte{}, key...), Found: found}
+	if expected != nil {
+		err.Expected = append([]byte{}, expected...)
+	}
+	if found {
+		err.Actual = append([]byte{}, current...)
+	}
+	return err
+}
+
+// EncodeCounter returns the value of a counter, 8 bytes in big endian
+func EncodeCounter(n int64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n))
+	return buf[:]
+}
+
+// DecodeCounter returns the counter of the value
+func DecodeCounter(key, value []byte) (int64, error) {
+	if len(value) != 8 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidCounter, key)
+	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
+
+// AddCounter returns the counter of the current value plus delta
+// the counter of a key that doesn't exist is zero
+func AddCounter(key, current []byte, found bool, delta int64) (int64, error) {
+	if !found {
+		return delta, nil
+	}
+	n, err := DecodeCounter(key, current)
+	if err != nil {
+		return 0, err
+	}
+	return n + delta, nil
+}
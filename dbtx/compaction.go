@@ -0,0 +1,15 @@
+package dbtx
+
+// Compaction report, sizes in bytes of the files before and after it
+type Compaction struct {
+	Before int64
+	After  int64
+}
+
+// Reclaimed returns the bytes released by the compaction, zero if the files grew
+func (c *Compaction) Reclaimed() int64 {
+	if c.After >= c.Before {
+		return 0
+	}
+	return c.Before - c.After
+}
@@ -0,0 +1,9 @@
+package dbtx
+
+import "errors"
+
+// ErrNotFound is returned by all drivers when the key doesn't exist
+var ErrNotFound = errors.New("key not found")
+
+// ErrNotSupported is returned when the driver hasn't the method or the option
+var ErrNotSupported = errors.New("not supported by the driver")
@@ -0,0 +1,23 @@
+package dbtx
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrReadOnly is matched by the ReadOnlyError of the writes in a read-only database
+var ErrReadOnly = errors.New("read-only database")
+
+// ReadOnlyError is returned by the writes in a database opened in read-only mode
+type ReadOnlyError struct {
+	Path string
+}
+
+func (e *ReadOnlyError) Error() string {
+	return fmt.Sprintf("%v: %s", ErrReadOnly, e.Path)
+}
+
+// Is matches ErrReadOnly
+func (e *ReadOnlyError) Is(target error) bool {
+	return target == ErrReadOnly
+}
@@ -0,0 +1,120 @@
+package dbtx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+)
+
+// ErrInvalidToken is returned by the scans when the token isn't of the same order and prefix
+var ErrInvalidToken = errors.New("invalid continuation token")
+
+// ScanOptions of a page
+// Prefix: only the keys with the prefix
+// Limit: max amount of key/values in the page, all if zero
+// Reverse: descending order of keys
+// Token: continuation token of the previous page, empty in the first one
+type ScanOptions struct {
+	Prefix  []byte
+	Limit   int
+	Reverse bool
+	Token   string
+}
+
+// KeyValue of a page
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// Page of a scan
+// Token: continuation token of the next page, empty if it's the last one
+// the next page starts after the last key of this page, so the keys inserted
+// between pages are only returned if they are after it
+type Page struct {
+	Items []KeyValue
+	Token string
+}
+
+// token: order | last key
+const (
+	forward byte = iota
+	reverse
+)
+
+// EncodeToken returns the continuation token after the key
+func EncodeToken(key []byte, rev bool) string {
+	order := forward
+	if rev {
+		order = reverse
+	}
+	return base64.RawURLEncoding.EncodeToString(append([]byte{order}, key...))
+}
+
+// DecodeToken returns the last key of the token, nil if the token is empty
+func DecodeToken(opts ScanOptions) ([]byte, error) {
+	if opts.Token == "" {
+		return nil, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(opts.Token)
+	if err != nil || len(buf) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	order := forward
+	if opts.Reverse {
+		order = reverse
+	}
+	if buf[0] != order || !bytes.HasPrefix(buf[1:], opts.Prefix) {
+		return nil, ErrInvalidToken
+	}
+	return buf[1:], nil
+}
+
+// PrefixEnd returns the first key after all keys with the prefix
+// nil if there is no such key, the prefix is empty or only has 0xff
+func PrefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Pager builds a page, the drivers call Full before adding each key/value
+// in the order of the scan
+type Pager struct {
+	opts ScanOptions
+	page Page
+}
+
+// NewPager of the options
+func NewPager(opts ScanOptions) *Pager {
+	return &Pager{opts: opts}
+}
+
+// Full returns true if the page is full, it's only called if there is a next key/value
+// so the page receives the token of the next one
+func (p *Pager) Full() bool {
+	if p.opts.Limit <= 0 || len(p.page.Items) < p.opts.Limit {
+		return false
+	}
+	p.page.Token = EncodeToken(p.page.Items[len(p.page.Items)-1].Key, p.opts.Reverse)
+	return true
+}
+
+// Add a copy of the key/value to the page
+func (p *Pager) Add(key, value []byte) {
+	p.page.Items = append(p.page.Items, KeyValue{
+		Key:   append([]byte{}, key...),
+		Value: append([]byte{}, value...),
+	})
+}
+
+// Page returns the page built
+func (p *Pager) Page() *Page {
+	return &p.page
+}
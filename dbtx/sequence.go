@@ -0,0 +1,12 @@
+package dbtx
+
+// SequencePrefix of the keys or buckets of the sequences, the key/values must not start with it
+var SequencePrefix = []byte("\xffseq\x00")
+
+// Sequence generates monotonic ids, starting at 1
+// the ids are unique after a crash, but there can be gaps between them
+// Release returns the ids leased and not used, the sequence can be used after it
+type Sequence interface {
+	Next() (uint64, error)
+	Release() error
+}
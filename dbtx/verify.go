@@ -0,0 +1,49 @@
+package dbtx
+
+import "fmt"
+
+// Problem found by a verification
+// Check: name of the check that found it, Key: nil if it isn't of one key
+type Problem struct {
+	Check string
+	Key   []byte
+	Err   error
+}
+
+func (p Problem) String() string {
+	if p.Key == nil {
+		return fmt.Sprintf("%s: %v", p.Check, p.Err)
+	}
+	return fmt.Sprintf("%s: key %q: %v", p.Check, p.Key, p.Err)
+}
+
+// Verification report
+// Keys: amount of key/values read, Problems: empty if the database is healthy
+type Verification struct {
+	Keys     int
+	Problems []Problem
+}
+
+// Healthy returns true if no problem was found
+func (v *Verification) Healthy() bool {
+	return len(v.Problems) == 0
+}
+
+// Add a problem with a copy of the key
+func (v *Verification) Add(check string, key []byte, err error) {
+	if key != nil {
+		key = append([]byte{}, key...)
+	}
+	v.Problems = append(v.Problems, Problem{Check: check, Key: key, Err: err})
+}
+
+// Safely runs fn and returns the panic as an error
+// the drivers may panic when they read corrupted pages
+func Safely(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return fn()
+}
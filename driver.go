@@ -6,16 +6,31 @@ package drivers
 
 import (
 	"fmt"
+	"os"
+	"time"
 
 	"github.com/plateausnetwork/drivers/badger"
 	"github.com/plateausnetwork/drivers/bolt"
 	"github.com/plateausnetwork/drivers/dbtx"
 	"github.com/plateausnetwork/drivers/ristretto"
+	"github.com/plateausnetwork/drivers/watch"
 )
 
 var (
 	// DefaultOptions for open a database connection
 	DefaultOptions = Options{Bucket: []byte("rhz")}
+
+	// ErrNotFound is returned by Get when the key doesn't exist
+	ErrNotFound = dbtx.ErrNotFound
+
+	// ErrPrecondition is matched by the errors of the conditional writes
+	ErrPrecondition = dbtx.ErrPrecondition
+
+	// ErrInvalidCounter is returned by Increment when the value isn't a counter
+	ErrInvalidCounter = dbtx.ErrInvalidCounter
+
+	// ErrReadOnly is matched by the errors of the writes in a read-only database
+	ErrReadOnly = dbtx.ErrReadOnly
 )
 
 // KeyValueDB driver signature
@@ -39,9 +54,10 @@ type Database interface {
 }
 
 // Reader all methods to read the database
-// Get: searches for a specific key/value
-// KeyIterator: iterates only in keys' tree
-// ForEach: apply rules with values from database
+// Get: searches for a specific key/value, ErrNotFound if the key doesn't exist
+// KeyIterator: iterates only in keys' tree, in ascending order of keys
+// ForEach: apply rules with values from database, in ascending order of keys
+// the iteration stops at the first error returned by the query
 // the queries must be in same scope, example:
 // var list [][]byte
 // query := func(v []byte) error {list=append(list,v)}
@@ -53,10 +69,116 @@ type Reader interface {
 
 // Writer all methods to write in database
 // Upsert will update the value if key exists
+// CompareAndSwap: writes the new value (the third) if the current one is the expected (the second),
+// expected nil means that the key must not exist and new nil deletes the key
+// PutIfAbsent: writes the key/value if the key doesn't exist
+// Increment: adds delta to the counter of the key, the counter of a key that doesn't exist is zero
+// the conditional writes return a *PreconditionError, matched by ErrPrecondition,
+// when the current value isn't the expected
 type Writer interface {
 	Upsert([]byte, []byte) error // update or insert
 	Delete([]byte) error         // delete the key/value
 	Update(dbtx.Execute) error
+	CompareAndSwap([]byte, []byte, []byte) error
+	PutIfAbsent([]byte, []byte) error
+	Increment([]byte, int64) (int64, error)
+}
+
+// PreconditionError of the conditional writes, see dbtx.PreconditionError
+type PreconditionError = dbtx.PreconditionError
+
+// ReadOnlyError of the writes in a read-only database, see dbtx.ReadOnlyError
+type ReadOnlyError = dbtx.ReadOnlyError
+
+// ScanOptions of a page, see dbtx.ScanOptions
+type ScanOptions = dbtx.ScanOptions
+
+// Page of key/values and the continuation token of the next one
+type Page = dbtx.Page
+
+// ErrInvalidToken is returned by Scan when the token isn't of the same order and prefix
+var ErrInvalidToken = dbtx.ErrInvalidToken
+
+// Scanner is implemented by the drivers that read pages of key/values
+// Scan: key/values with the prefix, in ascending or descending order of keys,
+// from the key after the token of the previous page
+type Scanner interface {
+	Scan(ScanOptions) (*Page, error)
+}
+
+// RangeDeleter is implemented by the drivers that delete many keys at once
+// DeletePrefix: deletes the keys with the prefix and returns how many were removed
+// DeleteRange: deletes the keys from start until end (exclusive), end nil is after the last key
+// CountPrefix: amount of keys with the prefix
+type RangeDeleter interface {
+	DeletePrefix([]byte) (int, error)
+	DeleteRange(start, end []byte) (int, error)
+	CountPrefix([]byte) (int, error)
+}
+
+// Sequence generates monotonic ids, see dbtx.Sequence
+type Sequence = dbtx.Sequence
+
+// Sequencer is implemented by the drivers with sequences of ids
+// Sequence: returns the sequence of the name, created if it doesn't exist
+type Sequencer interface {
+	Sequence([]byte) (Sequence, error)
+}
+
+// Event of a change committed in the database
+type Event = watch.Event
+
+// Watcher is implemented by the drivers that publish their changes
+// Watch: events of the keys with the prefix, in the order of the commits,
+// after each committed Upsert, Delete and Update; cancel closes the channel
+// the buffer of each subscriber is bounded, a slow subscriber is dropped and
+// its channel is closed, it must Watch again and reload the state
+// Clean isn't published by all drivers, the watchers must reload after it
+type Watcher interface {
+	Watch([]byte) (<-chan Event, func())
+}
+
+// Compaction report of the sizes before and after it, see dbtx.Compaction
+type Compaction = dbtx.Compaction
+
+// Compactor is implemented by the drivers that reclaim the space of the deleted data
+// Compact: rewrites the files of the database and reports the bytes reclaimed
+// bolt copies the key/values into a fresh file and badger runs the value log GC,
+// see the maintenance package to run it periodically
+type Compactor interface {
+	Compact() (*Compaction, error)
+}
+
+// Verification report of the problems found in a database, see dbtx.Verification
+type Verification = dbtx.Verification
+
+// Problem found by a verification
+type Problem = dbtx.Problem
+
+// Verifier is implemented by the drivers that check their files
+// Verify: checks the structures of the database and reads all key/values,
+// the error is only returned if the verification can't run
+type Verifier interface {
+	Verify() (*Verification, error)
+}
+
+// VersionedDB driver signature of the versioned mode
+// each write is tagged with a version (block height) and the reads
+// return the state as of a version
+// UpdateAt: writes all executions inside one transaction at the height
+// GetAt: value of the key at the height, ErrNotFound if it doesn't exist
+// ForEachAt: key/values at the height, in ascending order of keys
+// Prune: removes the versions not needed to read at the height or later
+type VersionedDB interface {
+	Type() int
+	Path() string
+	Close() error
+	UpsertAt(uint64, []byte, []byte) error
+	DeleteAt(uint64, []byte) error
+	UpdateAt(uint64, dbtx.Execute) error
+	GetAt([]byte, uint64) ([]byte, error)
+	ForEachAt(uint64, func(key, value []byte) error) error
+	Prune(uint64) error
 }
 
 // OptionsNil helps if the database has default values
@@ -64,9 +186,13 @@ var OptionsNil = Options{}
 
 // Options has all options to connect with any available driver
 type Options struct {
-	Bucket  []byte
-	Size    int64
-	Timeout int64
+	Bucket   []byte
+	Size     int64       // max cost of the ristretto cache, ristretto.DefaultMaxCost if zero
+	Timeout  int64       // max time waiting the lock of the bolt file, in nanoseconds
+	ReadOnly bool        // shared lock of bolt and badger, the writes return a *ReadOnlyError
+	InMemory bool        // not supported by badger v1 and bolt, ErrNotSupported
+	NoSync   bool        // the commits don't wait the fsync, the last ones may be lost in a crash
+	FileMode os.FileMode // mode of the bolt file or of the badger directory if they're created
 }
 
 // DriverOptions set specific or normal options for key/value databases
@@ -111,17 +237,58 @@ func ToDriverType(tp int) DriverType {
 func Open(dbtype DriverType, dbpath string, options Options) (KeyValueDB, error) {
 	switch dbtype {
 	case Boltdb:
-		return bolt.Open(int(Boltdb), dbpath, options.Bucket)
+		if options.InMemory {
+			return nil, fmt.Errorf("bolt has no in-memory mode: %w", ErrNotSupported)
+		}
+		return bolt.OpenWith(int(Boltdb), dbpath, options.Bucket, bolt.Options{
+			ReadOnly: options.ReadOnly,
+			NoSync:   options.NoSync,
+			FileMode: options.FileMode,
+			Timeout:  time.Duration(options.Timeout),
+		})
 	case Badgerdb:
-		return badger.Open(int(Badgerdb), dbpath)
+		return badger.OpenWith(int(Badgerdb), dbpath, badger.Options{
+			ReadOnly: options.ReadOnly,
+			InMemory: options.InMemory,
+			NoSync:   options.NoSync,
+			DirMode:  options.FileMode,
+		})
 	case Ristretto:
+		if options.ReadOnly {
+			return nil, fmt.Errorf("ristretto has no read-only mode")
+		}
 		// the path is the name of the cache
-		return ristretto.Open(int(Ristretto), dbpath)
+		return ristretto.OpenWith(int(Ristretto), dbpath, ristretto.Options{MaxCost: options.Size})
 	}
-	return nil, fmt.Errorf("inexistent database type " + string(dbtype))
+	return nil, fmt.Errorf("inexistent database type %d", dbtype)
+}
+
+// OpenVersioned returns the key/value database in versioned mode
+// the versioned mode is available for bolt and badger
+func OpenVersioned(dbtype DriverType, dbpath string, options Options) (VersionedDB, error) {
+	switch dbtype {
+	case Boltdb:
+		return bolt.OpenVersioned(int(Boltdb), dbpath, options.Bucket)
+	case Badgerdb:
+		return badger.OpenVersioned(int(Badgerdb), dbpath)
+	}
+	return nil, fmt.Errorf("database type %d has no versioned mode", dbtype)
 }
 
 // Int returns the DriverType as int
 func (dtp DriverType) Int() int {
 	return int(dtp)
 }
+
+// String returns the name of the driver, the scheme of its URLs
+func (dtp DriverType) String() string {
+	switch dtp {
+	case Boltdb:
+		return "bolt"
+	case Badgerdb:
+		return "badger"
+	case Ristretto:
+		return "ristretto"
+	}
+	return fmt.Sprintf("DriverType(%d)", int(dtp))
+}
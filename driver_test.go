@@ -1,9 +1,19 @@
 package drivers_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/quick"
+	"time"
 
 	dr "github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/drivertest"
 	"github.com/plateausnetwork/drivers/runners"
 )
 
@@ -110,3 +120,436 @@ func BenchmarkDatabases(b *testing.B) {
 		}
 	})
 }
+
+// builtinDrivers opened by the drivertest suites
+var builtinDrivers = map[string]dr.DriverType{
+	"bolt":      dr.Boltdb,
+	"badger":    dr.Badgerdb,
+	"ristretto": dr.Ristretto,
+}
+
+func openFunc(dbType dr.DriverType) drivertest.OpenFunc {
+	return func(dir string) (dr.KeyValueDB, error) {
+		opts := dr.DriverOptions()
+		opts.AddBucket(testBucket)
+		return dr.Open(dbType, dir+"/test.db", opts)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	for name, dbType := range builtinDrivers {
+		dbType := dbType
+		t.Run(name, func(t *testing.T) {
+			drivertest.RunConformance(t, openFunc(dbType), drivertest.Options{Buckets: dbType == dr.Boltdb})
+		})
+	}
+}
+
+func TestModel(t *testing.T) {
+	for name, dbType := range builtinDrivers {
+		dbType := dbType
+		t.Run(name, func(t *testing.T) {
+			drivertest.RunModel(t, openFunc(dbType), &quick.Config{MaxCount: 30})
+		})
+	}
+}
+
+func TestOpenVersioned(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db, err := dr.OpenVersioned(dr.Boltdb, dir+"/test.db", dr.DefaultOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		if err := db.UpsertAt(1, key, value); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if _, err := dr.OpenVersioned(dr.Ristretto, "cache", dr.Options{}); err == nil {
+		t.Error("ristretto has no versioned mode")
+	}
+}
+
+// readerOnly hides the Scanner of the driver, like the wrappers of other packages
+type readerOnly struct {
+	dr.KeyValueDB
+}
+
+func TestScanFallback(t *testing.T) {
+	drivertest.RunConformance(t, func(dir string) (dr.KeyValueDB, error) {
+		db, err := openFunc(dr.Boltdb)(dir)
+		return readerOnly{db}, err
+	}, drivertest.Options{Buckets: true})
+}
+
+// vanishing deletes the key after the iteration, before its Get
+type vanishing struct {
+	readerOnly
+	key []byte
+}
+
+func (db vanishing) Get(key []byte) ([]byte, error) {
+	if bytes.Equal(key, db.key) {
+		return nil, dr.ErrNotFound
+	}
+	return db.KeyValueDB.Get(key)
+}
+
+func TestScanFallbackDeleted(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db, err := openFunc(dr.Boltdb)(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		for i := 0; i < 5; i++ {
+			if err := db.Upsert([]byte(fmt.Sprintf("k%d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		reader := vanishing{readerOnly{db}, []byte("k2")}
+		var keys []string
+		opts := dr.ScanOptions{Limit: 2}
+		for {
+			page, err := dr.Scan(reader, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, kv := range page.Items {
+				keys = append(keys, string(kv.Key))
+			}
+			if page.Token == "" {
+				break
+			}
+			opts.Token = page.Token
+		}
+		if fmt.Sprint(keys) != "[k0 k1 k3 k4]" {
+			t.Errorf("expected the keys after the deleted one, got %v", keys)
+		}
+	})
+}
+
+// damaged fails to read the keys with the prefix bad/
+type damaged struct {
+	dr.KeyValueDB
+}
+
+func (db damaged) Get(key []byte) ([]byte, error) {
+	if bytes.HasPrefix(key, []byte("bad/")) {
+		panic("corrupted page")
+	}
+	return db.KeyValueDB.Get(key)
+}
+
+func TestSalvage(t *testing.T) {
+	runners.WithTempSubDirs(2, func(dirs []string) {
+		src, err := openFunc(dr.Boltdb)(dirs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		dst, err := openFunc(dr.Badgerdb)(dirs[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dst.Close()
+
+		for i := 0; i < 2500; i++ {
+			if err := src.Upsert([]byte(fmt.Sprintf("good/%05d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := src.Upsert([]byte("bad/1"), value); err != nil {
+			t.Fatal(err)
+		}
+
+		report, err := dr.Verify(damaged{src})
+		if err != nil || report.Keys != 2500 || len(report.Problems) != 1 {
+			t.Errorf("wrong report of the fallback %+v %v", report, err)
+		}
+
+		report, err = dr.Salvage(damaged{src}, dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Keys != 2500 || len(report.Problems) != 1 || string(report.Problems[0].Key) != "bad/1" {
+			t.Errorf("wrong report of the salvage %+v", report)
+		}
+		if dst.Length() != 2500 {
+			t.Errorf("expected 2500 salvaged keys, got %d", dst.Length())
+		}
+	})
+}
+
+// the read-only handles share the file and fail the writes with a typed error
+func TestReadOnly(t *testing.T) {
+	for name, dbType := range map[string]dr.DriverType{"bolt": dr.Boltdb, "badger": dr.Badgerdb} {
+		dbType := dbType
+		t.Run(name, func(t *testing.T) {
+			runners.WithTempDir(func(dir string) {
+				db, err := openFunc(dbType)(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := db.Upsert(key, value); err != nil {
+					t.Fatal(err)
+				}
+				db.Close()
+
+				opts := dr.Options{Bucket: testBucket, ReadOnly: true}
+				first, err := dr.Open(dbType, dir+"/test.db", opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer first.Close()
+				second, err := dr.Open(dbType, dir+"/test.db", opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer second.Close()
+
+				if v, err := second.Get(key); err != nil || !bytes.Equal(v, value) {
+					t.Errorf("wrong value in the read-only handle %v", err)
+				}
+				var roErr *dr.ReadOnlyError
+				if err := first.Upsert(key, value); !errors.Is(err, dr.ErrReadOnly) || !errors.As(err, &roErr) {
+					t.Errorf("expected a ReadOnlyError, got %v", err)
+				}
+				if _, err := first.Increment([]byte("counter"), 1); !errors.Is(err, dr.ErrReadOnly) {
+					t.Errorf("expected ErrReadOnly, got %v", err)
+				}
+				if _, err := dr.DeletePrefix(first, nil); !errors.Is(err, dr.ErrReadOnly) {
+					t.Errorf("expected ErrReadOnly, got %v", err)
+				}
+			})
+		})
+	}
+
+	if _, err := dr.Open(dr.Ristretto, "cache", dr.Options{ReadOnly: true}); err == nil {
+		t.Error("ristretto has no read-only mode")
+	}
+}
+
+func TestInMemory(t *testing.T) {
+	// badger v1 has no memory mode
+	if _, err := dr.Open(dr.Badgerdb, "", dr.Options{InMemory: true}); !errors.Is(err, dr.ErrNotSupported) {
+		t.Errorf("expected %v, received %v", dr.ErrNotSupported, err)
+	}
+	if _, err := dr.Open(dr.Boltdb, "test.db", dr.Options{InMemory: true}); !errors.Is(err, dr.ErrNotSupported) {
+		t.Errorf("expected %v, received %v", dr.ErrNotSupported, err)
+	}
+	if _, err := os.Stat("test.db"); !os.IsNotExist(err) {
+		t.Errorf("the bolt file must not be created %v", err)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db, err := dr.Open(dr.Boltdb, dir+"/test.db", dr.Options{Bucket: testBucket, FileMode: 0640, NoSync: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if info, err := os.Stat(dir + "/test.db"); err != nil || info.Mode().Perm() != 0640 {
+			t.Errorf("expected the mode 0640, got %v %v", info.Mode(), err)
+		}
+	})
+}
+
+func TestOpenShared(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		opts := dr.Options{Bucket: testBucket}
+		first, err := dr.OpenShared(dr.Boltdb, dir+"/test.db", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := dr.OpenShared(dr.Boltdb, dir+"/./test.db", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := first.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := dr.OpenShared(dr.Boltdb, dir+"/test.db", dr.Options{Bucket: testBucket, ReadOnly: true}); !errors.Is(err, dr.ErrConflictingOptions) {
+			t.Errorf("expected ErrConflictingOptions, got %v", err)
+		}
+
+		first.Close()
+		first.Close() // only the first close releases the holder
+		if _, err := first.Get(key); !errors.Is(err, dr.ErrClosed) {
+			t.Errorf("expected ErrClosed after the close of the holder, got %v", err)
+		}
+		if err := first.Upsert(key, value); !errors.Is(err, dr.ErrClosed) || first.Open() {
+			t.Errorf("expected ErrClosed after the close of the holder, got %v", err)
+		}
+		if v, err := second.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Errorf("the database was closed before the last holder %v", err)
+		}
+		if err := second.Close(); err != nil {
+			t.Error(err)
+		}
+
+		// the lock of the file was released
+		db, err := dr.Open(dr.Boltdb, dir+"/test.db", dr.Options{Bucket: testBucket, Timeout: int64(time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	})
+}
+
+func TestSharedConformance(t *testing.T) {
+	drivertest.RunConformance(t, func(dir string) (dr.KeyValueDB, error) {
+		return dr.OpenShared(dr.Badgerdb, dir+"/test.db", dr.DriverOptions())
+	}, drivertest.Options{})
+}
+
+func TestParseURL(t *testing.T) {
+	valid := map[string]dr.URL{
+		"bolt:///var/data/chain.db?bucket=rhz&timeout=5s": {Driver: dr.Boltdb, Path: "/var/data/chain.db",
+			Options: dr.Options{Bucket: []byte("rhz"), Timeout: int64(5 * time.Second)}},
+		"bolt:data/chain.db?mode=0640&readonly=true": {Driver: dr.Boltdb, Path: "data/chain.db",
+			Options: dr.Options{Bucket: dr.DefaultOptions.Bucket, FileMode: 0640, ReadOnly: true}},
+		"badger:///dir?inmemory=true&nosync=1": {Driver: dr.Badgerdb, Path: "/dir",
+			Options: dr.Options{InMemory: true, NoSync: true}},
+		"ristretto://name?maxcost=1e8": {Driver: dr.Ristretto, Path: "name",
+			Options: dr.Options{Size: 100000000}},
+	}
+	for raw, expected := range valid {
+		u, err := dr.ParseURL(raw)
+		if err != nil {
+			t.Errorf("%s: %v", raw, err)
+			continue
+		}
+		if !reflect.DeepEqual(*u, expected) {
+			t.Errorf("%s: expected %+v, got %+v", raw, expected, *u)
+		}
+		again, err := dr.ParseURL(u.String())
+		if err != nil || !reflect.DeepEqual(again, u) || again.String() != u.String() {
+			t.Errorf("%s: %s doesn't round-trip %v", raw, u, err)
+		}
+	}
+
+	invalid := map[string]string{
+		"leveldb:///data":                "scheme",
+		"bolt://host/data":               "host",
+		"bolt:///data?timeout=5":         "timeout",
+		"bolt:///data?timeout=-1s":       "timeout",
+		"bolt:///data?bucket=a&bucket=b": "bucket",
+		"badger:///data?bucket=rhz":      "bucket",
+		"badger:///data?readonly=maybe":  "readonly",
+		"badger:///data?mode=0999":       "mode",
+		"ristretto://name?maxcost=1.5":   "maxcost",
+		"ristretto://name/path":          "path",
+		"bolt://":                        "path",
+	}
+	for raw, param := range invalid {
+		_, err := dr.ParseURL(raw)
+		var urlErr *dr.URLError
+		if !errors.As(err, &urlErr) || !errors.Is(err, dr.ErrInvalidURL) || urlErr.Param != param {
+			t.Errorf("%s: expected an error of %s, got %v", raw, param, err)
+		}
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		for _, raw := range []string{
+			"bolt://" + dir + "/chain.db?bucket=rhz&timeout=5s",
+			"badger://" + dir + "/state?nosync=true",
+			"ristretto://cache?maxcost=1e8",
+		} {
+			db, err := dr.OpenURL(raw)
+			if err != nil {
+				t.Errorf("%s: %v", raw, err)
+				continue
+			}
+			if err := db.Upsert(key, value); err != nil {
+				t.Errorf("%s: %v", raw, err)
+			}
+			db.Close()
+		}
+	})
+}
+
+func writeConfig(t *testing.T, dir, config string) string {
+	path := dir + "/stores.json"
+	if err := ioutil.WriteFile(path, []byte(strings.ReplaceAll(config, "DIR", dir)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadStores(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		stores, err := dr.LoadStores(writeConfig(t, dir, `{"stores": {
+			"blocks": {"driver": "bolt", "path": "DIR/blocks.db", "buckets": ["headers"],
+				"options": {"bucket": "blocks", "timeout": "5s", "nosync": true}},
+			"state": {"driver": "badger", "path": "DIR/state", "options": {"nosync": true}},
+			"cache": {"driver": "ristretto", "path": "cache", "options": {"maxcost": 1e8}}
+		}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := stores.Names(); !reflect.DeepEqual(names, []string{"blocks", "cache", "state"}) {
+			t.Errorf("wrong names %v", names)
+		}
+		for _, name := range stores.Names() {
+			db, _ := stores.Get(name)
+			if err := db.Upsert(key, value); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+		}
+		if _, ok := stores.Get("peers"); ok {
+			t.Error("the store isn't in the config")
+		}
+		if err := stores.Close(); err != nil {
+			t.Error(err)
+		}
+
+		// the headers bucket was created and blocks is still the current one
+		db, err := dr.Open(dr.Boltdb, dir+"/blocks.db", dr.Options{Bucket: []byte("headers"), ReadOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get(key); !errors.Is(err, dr.ErrNotFound) {
+			t.Errorf("the key was written in the wrong bucket %v", err)
+		}
+		db.Close()
+	})
+}
+
+func TestLoadStoresRollback(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		_, err := dr.LoadStores(writeConfig(t, dir, `{"stores": {
+			"a": {"driver": "bolt", "path": "DIR/a.db"},
+			"b": {"driver": "bolt", "path": "DIR/missing/b.db"}
+		}}`))
+		if err == nil || !strings.Contains(err.Error(), `"b"`) {
+			t.Fatalf("expected an error of the store b, got %v", err)
+		}
+		// the store a was closed
+		db, err := dr.Open(dr.Boltdb, dir+"/a.db", dr.Options{Bucket: testBucket, Timeout: int64(time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+
+		// the config is validated before opening
+		_, err = dr.LoadStores(writeConfig(t, dir, `{"stores": {
+			"a": {"driver": "bolt", "path": "DIR/c.db"},
+			"b": {"driver": "badger", "path": "DIR/d", "options": {"timeout": "5s"}}
+		}}`))
+		var urlErr *dr.URLError
+		if !errors.As(err, &urlErr) || urlErr.Param != "timeout" {
+			t.Errorf("expected an error of the timeout, got %v", err)
+		}
+		if _, err := os.Stat(dir + "/c.db"); !os.IsNotExist(err) {
+			t.Error("the store a was opened before the validation")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package drivertest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+// RunConditional runs only the test of the conditional writes
+// it's used by the wrappers of KeyValueDB that can't run the full suite, like the ones with reserved keys
+func RunConditional(t *testing.T, open OpenFunc) {
+	runners.WithTempDir(func(dir string) {
+		db, err := open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		testConditional(t, db)
+	})
+}
+
+func expectPrecondition(t *testing.T, name string, err error, found bool) {
+	t.Helper()
+	var precondition *drivers.PreconditionError
+	if !errors.Is(err, drivers.ErrPrecondition) || !errors.As(err, &precondition) {
+		t.Errorf("%s: expected a PreconditionError, got %v", name, err)
+		return
+	}
+	if precondition.Found != found {
+		t.Errorf("%s: expected found %v, got %v", name, found, precondition.Found)
+	}
+}
+
+func testConditional(t *testing.T, db drivers.KeyValueDB) {
+	a, b, c := keys[0], keys[1], keys[2]
+
+	if err := db.PutIfAbsent(a, valueOf(a)); err != nil {
+		t.Fatal(err)
+	}
+	expectPrecondition(t, "put if absent", db.PutIfAbsent(a, []byte("x")), true)
+	expectValue(t, db, a, valueOf(a))
+
+	expectPrecondition(t, "swap unexpected", db.CompareAndSwap(a, []byte("x"), []byte("y")), true)
+	if err := db.CompareAndSwap(a, valueOf(a), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, a, []byte("x"))
+	expectPrecondition(t, "swap absent", db.CompareAndSwap(b, []byte("x"), []byte("y")), false)
+
+	// the empty value exists, it's different of nil
+	if err := db.CompareAndSwap(b, nil, []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CompareAndSwap(b, []byte{}, valueOf(b)); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, b, valueOf(b))
+
+	// the nil value deletes the key
+	if err := db.CompareAndSwap(a, []byte("x"), nil); err != nil {
+		t.Fatal(err)
+	}
+	expectNotFound(t, db, a)
+
+	for _, step := range []struct {
+		delta, expected int64
+	}{{5, 5}, {-7, -2}, {2, 0}} {
+		n, err := db.Increment(c, step.delta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != step.expected {
+			t.Errorf("increment %d: expected %d, got %d", step.delta, step.expected, n)
+		}
+	}
+	expectValue(t, db, c, dbtx.EncodeCounter(0))
+	if _, err := db.Increment(b, 1); !errors.Is(err, drivers.ErrInvalidCounter) {
+		t.Errorf("expected ErrInvalidCounter, got %v", err)
+	}
+
+	// the increments and swaps of concurrent writers aren't lost
+	const writers, times = 4, 25
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				if _, err := db.Increment(c, 1); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				if err := swapIncrement(db, a); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	expectValue(t, db, c, dbtx.EncodeCounter(writers*times))
+	expectValue(t, db, a, dbtx.EncodeCounter(writers*times))
+}
+
+// swapIncrement increments the counter with CompareAndSwap until there is no other writer
+func swapIncrement(db drivers.KeyValueDB, key []byte) error {
+	for {
+		current, err := db.Get(key)
+		if errors.Is(err, drivers.ErrNotFound) {
+			current = nil
+		} else if err != nil {
+			return err
+		}
+
+		var n int64
+		if current != nil {
+			if n, err = dbtx.DecodeCounter(key, current); err != nil {
+				return err
+			}
+		}
+		err = db.CompareAndSwap(key, current, dbtx.EncodeCounter(n+1))
+		if !errors.Is(err, drivers.ErrPrecondition) {
+			return err
+		}
+	}
+}
@@ -0,0 +1,395 @@
+/*
+	Package drivertest has the conformance suite of drivers pkg.
+	All drivers must have the same behavior, the suite can be used by the
+	built-in drivers and by the third-party ones:
+
+	func TestConformance(t *testing.T) {
+		drivertest.RunConformance(t, func(dir string) (drivers.KeyValueDB, error) {
+			return mydriver.Open(dir)
+		}, drivertest.Options{Buckets: true})
+	}
+*/
+
+package drivertest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+// OpenFunc opens a new and empty database inside of the temporary dir
+type OpenFunc func(dir string) (drivers.KeyValueDB, error)
+
+// errQuery is returned by the queries to check the error handling
+var errQuery = errors.New("drivertest: query error")
+
+// sorted keys used by the suite, the values are the keys with the "v" prefix
+var keys = [][]byte{
+	[]byte("a"),
+	[]byte("b"),
+	[]byte("b1"),
+	[]byte("c"),
+}
+
+func valueOf(key []byte) []byte {
+	return append([]byte("v"), key...)
+}
+
+// Options of the suite, the features of the driver
+// Buckets: CreateBuckets switches to an isolated bucket, otherwise all buckets
+// are the same keyspace
+type Options struct {
+	Buckets bool
+}
+
+// RunConformance runs all tests of the suite as subtests of t
+// each subtest opens a new database with open
+func RunConformance(t *testing.T, open OpenFunc, opts Options) {
+	tests := []struct {
+		name string
+		test func(*testing.T, drivers.KeyValueDB)
+	}{
+		{"Database", testDatabase},
+		{"Get", testGet},
+		{"Upsert", testUpsert},
+		{"Delete", testDelete},
+		{"ForEach", testForEach},
+		{"KeyIterator", testKeyIterator},
+		{"QueryError", testQueryError},
+		{"Length", testLength},
+		{"Clean", testClean},
+		{"Update", testUpdate},
+		{"UpdateRollback", testUpdateRollback},
+		{"Buckets", func(t *testing.T, db drivers.KeyValueDB) { testBuckets(t, db, opts.Buckets) }},
+		{"Watch", testWatch},
+		{"Scan", testScan},
+		{"DeleteRange", testDeleteRange},
+		{"Conditional", testConditional},
+		{"Sequence", testSequence},
+	}
+
+	for _, tt := range tests {
+		test := tt.test
+		t.Run(tt.name, func(t *testing.T) {
+			runners.WithTempDir(func(dir string) {
+				db, err := open(dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+
+				test(t, db)
+			})
+		})
+	}
+}
+
+// fill the database with all keys of the suite, in reverse order
+func fill(t *testing.T, db drivers.KeyValueDB) {
+	t.Helper()
+	for i := len(keys) - 1; i >= 0; i-- {
+		if err := db.Upsert(keys[i], valueOf(keys[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectValue checks if the key has the value
+func expectValue(t *testing.T, db drivers.KeyValueDB, key, value []byte) {
+	t.Helper()
+	v, err := db.Get(key)
+	if err != nil {
+		t.Errorf("get %q: %v", key, err)
+		return
+	}
+	if !bytes.Equal(v, value) {
+		t.Errorf("get %q: expected %q, received %q", key, value, v)
+	}
+}
+
+// expectNotFound checks if the key doesn't exist
+func expectNotFound(t *testing.T, db drivers.KeyValueDB, key []byte) {
+	t.Helper()
+	if _, err := db.Get(key); !errors.Is(err, drivers.ErrNotFound) {
+		t.Errorf("get %q: expected %v, received %v", key, drivers.ErrNotFound, err)
+	}
+}
+
+// expectKeys checks if the database has exactly the keys, in the same order
+func expectKeys(t *testing.T, db drivers.KeyValueDB, expected ...[]byte) {
+	t.Helper()
+	var list [][]byte
+	if err := db.KeyIterator(func(k []byte) error {
+		list = append(list, k)
+		return nil
+	}); err != nil {
+		t.Error(err)
+		return
+	}
+
+	if fmt.Sprintf("%q", list) != fmt.Sprintf("%q", expected) {
+		t.Errorf("expected keys %q, received %q", expected, list)
+	}
+}
+
+func testDatabase(t *testing.T, db drivers.KeyValueDB) {
+	if !db.Open() {
+		t.Error("the database must be open")
+	}
+
+	if db.Path() == "" {
+		t.Error("the path cannot be empty")
+	}
+
+	fill(t, db)
+	if size, err := db.Size(); err != nil || size < 0 {
+		t.Errorf("invalid size %d: %v", size, err)
+	}
+}
+
+func testGet(t *testing.T, db drivers.KeyValueDB) {
+	expectNotFound(t, db, keys[0])
+
+	fill(t, db)
+	for _, k := range keys {
+		expectValue(t, db, k, valueOf(k))
+	}
+
+	// the returned value must not change with the next writes
+	v, err := db.Get(keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Upsert(keys[0], []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v, valueOf(keys[0])) {
+		t.Error("the returned value changed after a write")
+	}
+}
+
+func testUpsert(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	// update an existing key
+	if err := db.Upsert(keys[1], []byte("changed")); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, keys[1], []byte("changed"))
+
+	// empty values are valid
+	if err := db.Upsert(keys[2], []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, keys[2], []byte{})
+
+	if db.Length() != len(keys) {
+		t.Errorf("updates must not change the length: %d", db.Length())
+	}
+}
+
+func testDelete(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	if err := db.Delete(keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	expectNotFound(t, db, keys[1])
+	expectKeys(t, db, keys[0], keys[2], keys[3])
+
+	// delete an inexistent key isn't an error
+	if err := db.Delete([]byte("inexistent")); err != nil {
+		t.Errorf("delete of an inexistent key: %v", err)
+	}
+}
+
+func testForEach(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	var values [][]byte
+	if err := db.ForEach(func(v []byte) error {
+		values = append(values, v)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := make([][]byte, len(keys))
+	for i, k := range keys {
+		expected[i] = valueOf(k)
+	}
+
+	if fmt.Sprintf("%q", values) != fmt.Sprintf("%q", expected) {
+		t.Errorf("expected values %q, received %q", expected, values)
+	}
+}
+
+func testKeyIterator(t *testing.T, db drivers.KeyValueDB) {
+	expectKeys(t, db)
+
+	fill(t, db)
+	expectKeys(t, db, keys...)
+}
+
+func testQueryError(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	// the iteration stops at the first error
+	calls := 0
+	query := func([]byte) error {
+		calls++
+		return errQuery
+	}
+
+	if err := db.ForEach(query); !errors.Is(err, errQuery) {
+		t.Errorf("ForEach: expected %v, received %v", errQuery, err)
+	}
+	if calls != 1 {
+		t.Errorf("ForEach: the query was called %d times after an error", calls)
+	}
+
+	calls = 0
+	if err := db.KeyIterator(query); !errors.Is(err, errQuery) {
+		t.Errorf("KeyIterator: expected %v, received %v", errQuery, err)
+	}
+	if calls != 1 {
+		t.Errorf("KeyIterator: the query was called %d times after an error", calls)
+	}
+}
+
+func testLength(t *testing.T, db drivers.KeyValueDB) {
+	if db.Length() != 0 {
+		t.Errorf("a new database must be empty: %d", db.Length())
+	}
+
+	fill(t, db)
+	if db.Length() != len(keys) {
+		t.Errorf("expected length %d, received %d", len(keys), db.Length())
+	}
+
+	if err := db.Delete(keys[0]); err != nil {
+		t.Fatal(err)
+	}
+	if db.Length() != len(keys)-1 {
+		t.Errorf("expected length %d, received %d", len(keys)-1, db.Length())
+	}
+}
+
+func testClean(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	db.Clean()
+	if db.Length() != 0 {
+		t.Errorf("the length must be zero after clean: %d", db.Length())
+	}
+	expectNotFound(t, db, keys[0])
+	expectKeys(t, db)
+
+	// the database must still work after clean
+	if err := db.Upsert(keys[0], valueOf(keys[0])); err != nil {
+		t.Fatal(err)
+	}
+	expectValue(t, db, keys[0], valueOf(keys[0]))
+}
+
+func testUpdate(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	err := db.Update(func(bkt dbtx.Bucket) error {
+		if err := bkt.Put([]byte("d"), []byte("vd")); err != nil {
+			return err
+		}
+		if err := bkt.Put(keys[0], []byte("changed")); err != nil {
+			return err
+		}
+		return bkt.Delete(keys[1])
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectValue(t, db, []byte("d"), []byte("vd"))
+	expectValue(t, db, keys[0], []byte("changed"))
+	expectNotFound(t, db, keys[1])
+}
+
+func testUpdateRollback(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	err := db.Update(func(bkt dbtx.Bucket) error {
+		if err := bkt.Put([]byte("d"), []byte("vd")); err != nil {
+			return err
+		}
+		if err := bkt.Put(keys[0], []byte("changed")); err != nil {
+			return err
+		}
+		if err := bkt.Delete(keys[1]); err != nil {
+			return err
+		}
+		return errQuery
+	})
+	if !errors.Is(err, errQuery) {
+		t.Errorf("expected %v, received %v", errQuery, err)
+	}
+
+	// nothing must be applied
+	expectNotFound(t, db, []byte("d"))
+	expectKeys(t, db, keys...)
+	for _, k := range keys {
+		expectValue(t, db, k, valueOf(k))
+	}
+}
+
+func testBuckets(t *testing.T, db drivers.KeyValueDB, buckets bool) {
+	bucket, other := []byte("drivertest"), []byte("drivertest2")
+	key := []byte("bucket")
+
+	// empty buckets are ignored
+	if err := db.CreateBuckets([]byte{}); err != nil {
+		t.Errorf("create an empty bucket: %v", err)
+	}
+
+	// the key/values of the default bucket
+	fill(t, db)
+
+	// the created bucket is the current one
+	if err := db.CreateBuckets(bucket); err != nil {
+		t.Fatal(err)
+	}
+	if !buckets {
+		// the drivers without buckets have only one keyspace
+		expectKeys(t, db, keys...)
+		return
+	}
+	defer func() {
+		if err := db.DeleteBuckets(bucket, other); err != nil {
+			t.Errorf("delete buckets: %v", err)
+		}
+	}()
+	expectKeys(t, db)
+	expectNotFound(t, db, keys[0])
+	if err := db.Upsert(key, valueOf(key)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys of each bucket are isolated
+	if err := db.CreateBuckets(other); err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, db)
+
+	if err := db.CreateBuckets(bucket); err != nil {
+		t.Fatal(err)
+	}
+	expectKeys(t, db, key)
+	expectValue(t, db, key, valueOf(key))
+	if db.Length() != 1 {
+		t.Errorf("expected length 1, received %d", db.Length())
+	}
+}
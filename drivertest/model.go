@@ -0,0 +1,309 @@
+package drivertest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+// kinds of operations applied by the model
+const (
+	opUpsert = iota
+	opDelete
+	opUpdate
+	opClean
+	opGet
+	opIterate
+)
+
+// few keys, so the operations change the same keys many times
+const modelKeys = 6
+
+// operation of the model, ops and fail are used only by updates
+type operation struct {
+	kind  int
+	key   []byte
+	value []byte
+	ops   []operation
+	fail  bool
+}
+
+func (op operation) String() string {
+	switch op.kind {
+	case opUpsert:
+		return fmt.Sprintf("Upsert(%q, %q)", op.key, op.value)
+	case opDelete:
+		return fmt.Sprintf("Delete(%q)", op.key)
+	case opUpdate:
+		ops := make([]string, len(op.ops))
+		for i, o := range op.ops {
+			ops[i] = o.String()
+		}
+		return fmt.Sprintf("Update([%s], fail=%v)", strings.Join(ops, ", "), op.fail)
+	case opClean:
+		return "Clean()"
+	case opGet:
+		return fmt.Sprintf("Get(%q)", op.key)
+	}
+	return "Iterate()"
+}
+
+// sequence of operations generated by testing/quick
+type sequence []operation
+
+func randomWrite(rnd *rand.Rand) operation {
+	key := []byte(fmt.Sprintf("k%d", rnd.Intn(modelKeys)))
+	if rnd.Intn(3) == 0 {
+		return operation{kind: opDelete, key: key}
+	}
+	return operation{kind: opUpsert, key: key, value: []byte(fmt.Sprintf("v%d", rnd.Intn(100)))}
+}
+
+// Generate implements quick.Generator
+func (sequence) Generate(rnd *rand.Rand, size int) reflect.Value {
+	seq := make(sequence, rnd.Intn(size+1))
+	for i := range seq {
+		switch n := rnd.Intn(20); {
+		case n < 8:
+			seq[i] = randomWrite(rnd)
+		case n < 11:
+			op := operation{kind: opUpdate, fail: rnd.Intn(3) == 0}
+			for j := rnd.Intn(4); j >= 0; j-- {
+				op.ops = append(op.ops, randomWrite(rnd))
+			}
+			seq[i] = op
+		case n < 12:
+			seq[i] = operation{kind: opClean}
+		case n < 16:
+			seq[i] = operation{kind: opGet, key: []byte(fmt.Sprintf("k%d", rnd.Intn(modelKeys)))}
+		default:
+			seq[i] = operation{kind: opIterate}
+		}
+	}
+	return reflect.ValueOf(seq)
+}
+
+// model is the reference implementation of a key/value database
+type model map[string][]byte
+
+func (m model) apply(op operation) {
+	switch op.kind {
+	case opUpsert:
+		m[string(op.key)] = op.value
+	case opDelete:
+		delete(m, string(op.key))
+	case opUpdate:
+		if op.fail {
+			return
+		}
+		for _, o := range op.ops {
+			m.apply(o)
+		}
+	case opClean:
+		for k := range m {
+			delete(m, k)
+		}
+	}
+}
+
+// dump the model as the drivers iterate, in ascending order of keys
+func (m model) dump() string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&buf, "%q=%q ", k, m[k])
+	}
+	return buf.String()
+}
+
+// dump the database with KeyIterator and ForEach
+func dump(db drivers.KeyValueDB) (string, error) {
+	var keys, values [][]byte
+	if err := db.KeyIterator(func(k []byte) error {
+		keys = append(keys, k)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if err := db.ForEach(func(v []byte) error {
+		values = append(values, v)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+	if len(keys) != len(values) {
+		return "", fmt.Errorf("KeyIterator returned %d keys and ForEach %d values", len(keys), len(values))
+	}
+	if db.Length() != len(keys) {
+		return "", fmt.Errorf("Length is %d, but there are %d keys", db.Length(), len(keys))
+	}
+
+	var buf strings.Builder
+	for i := range keys {
+		fmt.Fprintf(&buf, "%q=%q ", keys[i], values[i])
+	}
+	return buf.String(), nil
+}
+
+var errModelFail = errors.New("drivertest: model failure")
+
+// exec applies the operation in the database
+func exec(db drivers.KeyValueDB, op operation) error {
+	switch op.kind {
+	case opUpsert:
+		return db.Upsert(op.key, op.value)
+	case opDelete:
+		return db.Delete(op.key)
+	case opUpdate:
+		err := db.Update(func(bkt dbtx.Bucket) error {
+			for _, o := range op.ops {
+				var err error
+				if o.kind == opDelete {
+					err = bkt.Delete(o.key)
+				} else {
+					err = bkt.Put(o.key, o.value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			if op.fail {
+				return errModelFail
+			}
+			return nil
+		})
+		if op.fail && errors.Is(err, errModelFail) {
+			return nil
+		}
+		return err
+	case opClean:
+		db.Clean()
+	}
+	return nil
+}
+
+// divergence returns the description of the first operation where
+// the database and the model disagree, or an empty string
+func divergence(db drivers.KeyValueDB, seq sequence) string {
+	m := model{}
+	for i, op := range seq {
+		if err := exec(db, op); err != nil {
+			return fmt.Sprintf("op %d %v: %v", i, op, err)
+		}
+		m.apply(op)
+
+		switch op.kind {
+		case opGet:
+			v, err := db.Get(op.key)
+			expected, ok := m[string(op.key)]
+			if !ok && !errors.Is(err, drivers.ErrNotFound) {
+				return fmt.Sprintf("op %d %v: expected %v, received %q, %v", i, op, drivers.ErrNotFound, v, err)
+			}
+			if ok && (err != nil || !bytes.Equal(v, expected)) {
+				return fmt.Sprintf("op %d %v: expected %q, received %q, %v", i, op, expected, v, err)
+			}
+		case opIterate:
+			state, err := dump(db)
+			if err != nil {
+				return fmt.Sprintf("op %d %v: %v", i, op, err)
+			}
+			if state != m.dump() {
+				return fmt.Sprintf("op %d %v: expected {%s}, received {%s}", i, op, m.dump(), state)
+			}
+		}
+	}
+
+	// the final state is always checked
+	state, err := dump(db)
+	if err != nil {
+		return fmt.Sprintf("end: %v", err)
+	}
+	if state != m.dump() {
+		return fmt.Sprintf("end: expected {%s}, received {%s}", m.dump(), state)
+	}
+	return ""
+}
+
+// run the sequence in a new database
+func run(t *testing.T, open OpenFunc, seq sequence) string {
+	var result string
+	runners.WithTempDir(func(dir string) {
+		db, err := open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		result = divergence(db, seq)
+	})
+	return result
+}
+
+// shrink removes operations while the sequence still diverges
+func shrink(t *testing.T, open OpenFunc, seq sequence) sequence {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(seq); i++ {
+			candidate := append(append(sequence{}, seq[:i]...), seq[i+1:]...)
+			if run(t, open, candidate) != "" {
+				seq, changed = candidate, true
+				i--
+				continue
+			}
+
+			// try to remove the operations inside of an update
+			for j := 0; seq[i].kind == opUpdate && j < len(seq[i].ops); j++ {
+				op := seq[i]
+				op.ops = append(append([]operation{}, op.ops[:j]...), op.ops[j+1:]...)
+				candidate := append(append(sequence{}, seq[:i]...), op)
+				candidate = append(candidate, seq[i+1:]...)
+				if run(t, open, candidate) != "" {
+					seq, changed = candidate, true
+					j--
+				}
+			}
+		}
+	}
+	return seq
+}
+
+// RunModel applies random sequences of operations to new databases and to
+// a reference map, the first divergence is shrunk and reported
+// config can be nil to use the testing/quick defaults
+func RunModel(t *testing.T, open OpenFunc, config *quick.Config) {
+	check := func(seq sequence) bool {
+		return run(t, open, seq) == ""
+	}
+
+	err := quick.Check(check, config)
+	if err == nil {
+		return
+	}
+
+	checkErr, ok := err.(*quick.CheckError)
+	if !ok {
+		t.Fatal(err)
+	}
+
+	seq := shrink(t, open, checkErr.In[0].(sequence))
+	ops := make([]string, len(seq))
+	for i, op := range seq {
+		ops[i] = fmt.Sprintf("\t%d: %v", i, op)
+	}
+	t.Errorf("divergence after %d random sequences: %s\nshrunk sequence:\n%s",
+		checkErr.Count, run(t, open, seq), strings.Join(ops, "\n"))
+}
@@ -0,0 +1,43 @@
+package drivertest
+
+import (
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+)
+
+func expectCount(t *testing.T, name string, count int, err error, expected int) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if count != expected {
+		t.Errorf("%s: expected %d keys, got %d", name, expected, count)
+	}
+}
+
+func testDeleteRange(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	count, err := drivers.CountPrefix(db, []byte("b"))
+	expectCount(t, "count b", count, err, 2)
+	count, err = drivers.CountPrefix(db, nil)
+	expectCount(t, "count all", count, err, len(keys))
+	count, err = drivers.CountPrefix(db, []byte("d"))
+	expectCount(t, "count d", count, err, 0)
+
+	count, err = drivers.DeleteRange(db, []byte("a"), []byte("b1"))
+	expectCount(t, "delete [a, b1)", count, err, 2)
+	expectKeys(t, db, keys[2:]...)
+
+	count, err = drivers.DeletePrefix(db, []byte("b"))
+	expectCount(t, "delete b", count, err, 1)
+	expectKeys(t, db, keys[3])
+
+	count, err = drivers.DeletePrefix(db, []byte("x"))
+	expectCount(t, "delete x", count, err, 0)
+
+	count, err = drivers.DeleteRange(db, nil, nil)
+	expectCount(t, "delete all", count, err, 1)
+	expectKeys(t, db)
+}
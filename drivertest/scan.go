@@ -0,0 +1,104 @@
+package drivertest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+)
+
+// scanPages returns the keys of each page until the last one
+func scanPages(t *testing.T, db drivers.KeyValueDB, opts drivers.ScanOptions, between func()) [][]string {
+	t.Helper()
+	var pages [][]string
+	for {
+		page, err := drivers.Scan(db, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, kv := range page.Items {
+			if string(kv.Value) != string(valueOf(kv.Key)) {
+				t.Errorf("%s: unexpected value %q", kv.Key, kv.Value)
+			}
+			keys = append(keys, string(kv.Key))
+		}
+		pages = append(pages, keys)
+
+		if page.Token == "" {
+			return pages
+		}
+		if len(pages) > 100 {
+			t.Fatal("the scan doesn't end")
+		}
+		opts.Token = page.Token
+		if between != nil {
+			between()
+		}
+	}
+}
+
+func expectPages(t *testing.T, name string, pages [][]string, expected ...[]string) {
+	t.Helper()
+	if len(pages) != len(expected) {
+		t.Errorf("%s: expected the pages %q, got %q", name, expected, pages)
+		return
+	}
+	for i := range pages {
+		if len(pages[i]) != len(expected[i]) {
+			t.Errorf("%s: expected the pages %q, got %q", name, expected, pages)
+			return
+		}
+		for j := range pages[i] {
+			if pages[i][j] != expected[i][j] {
+				t.Errorf("%s: expected the pages %q, got %q", name, expected, pages)
+				return
+			}
+		}
+	}
+}
+
+func testScan(t *testing.T, db drivers.KeyValueDB) {
+	fill(t, db)
+
+	expectPages(t, "all", scanPages(t, db, drivers.ScanOptions{}, nil),
+		[]string{"a", "b", "b1", "c"})
+	expectPages(t, "forward", scanPages(t, db, drivers.ScanOptions{Limit: 3}, nil),
+		[]string{"a", "b", "b1"}, []string{"c"})
+	expectPages(t, "reverse", scanPages(t, db, drivers.ScanOptions{Limit: 2, Reverse: true}, nil),
+		[]string{"c", "b1"}, []string{"b", "a"})
+	expectPages(t, "prefix", scanPages(t, db, drivers.ScanOptions{Prefix: []byte("b"), Limit: 1}, nil),
+		[]string{"b"}, []string{"b1"})
+	expectPages(t, "reverse prefix", scanPages(t, db, drivers.ScanOptions{Prefix: []byte("b"), Reverse: true}, nil),
+		[]string{"b1", "b"})
+	expectPages(t, "no keys", scanPages(t, db, drivers.ScanOptions{Prefix: []byte("d")}, nil),
+		nil)
+
+	// the keys inserted before the token aren't returned, the ones after it are
+	inserted := false
+	insert := func() {
+		if inserted {
+			return
+		}
+		inserted = true
+		for _, key := range []string{"0", "b0"} {
+			if err := db.Upsert([]byte(key), valueOf([]byte(key))); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	expectPages(t, "insert between pages", scanPages(t, db, drivers.ScanOptions{Limit: 2}, insert),
+		[]string{"a", "b"}, []string{"b0", "b1"}, []string{"c"})
+
+	page, err := drivers.Scan(db, drivers.ScanOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = drivers.Scan(db, drivers.ScanOptions{Limit: 1, Reverse: true, Token: page.Token})
+	if !errors.Is(err, drivers.ErrInvalidToken) {
+		t.Errorf("the token of other order must be invalid, got %v", err)
+	}
+	if _, err := drivers.Scan(db, drivers.ScanOptions{Token: "!"}); !errors.Is(err, drivers.ErrInvalidToken) {
+		t.Errorf("expected ErrInvalidToken, got %v", err)
+	}
+}
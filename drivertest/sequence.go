@@ -0,0 +1,85 @@
+package drivertest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+)
+
+// testSequence is skipped if the driver isn't a drivers.Sequencer
+func testSequence(t *testing.T, db drivers.KeyValueDB) {
+	sequencer, ok := db.(drivers.Sequencer)
+	if !ok {
+		t.Skip("the driver doesn't implement drivers.Sequencer")
+	}
+
+	if _, err := sequencer.Sequence(nil); err == nil {
+		t.Error("the empty name must return an error")
+	}
+
+	if err := db.Upsert([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	seq, err := sequencer.Sequence([]byte("ids"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last uint64
+	for i := 0; i < 50; i++ {
+		n, err := seq.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n <= last {
+			t.Fatalf("the id %d isn't greater than %d", n, last)
+		}
+		last = n
+	}
+	// the sequences aren't key/values
+	expectKeys(t, db, []byte("key"))
+	if n := db.Length(); n != 1 {
+		t.Errorf("expected the length 1, got %d", n)
+	}
+	if err := seq.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the sequence of the same name continues after the release and Clean
+	db.Clean()
+	again, err := sequencer.Sequence([]byte("ids"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := again.Next(); err != nil || n <= last {
+		t.Errorf("expected an id greater than %d, got %d %v", last, n, err)
+	}
+
+	// the concurrent ids are unique
+	const writers, times = 4, 50
+	ids := make(chan uint64, writers*times)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < times; j++ {
+				n, err := again.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- n
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[uint64]bool)
+	for n := range ids {
+		if seen[n] {
+			t.Errorf("the id %d was returned twice", n)
+		}
+		seen[n] = true
+	}
+}
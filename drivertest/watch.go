@@ -0,0 +1,83 @@
+package drivertest
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/watch"
+)
+
+// watchTimeout to receive an event
+const watchTimeout = 5 * time.Second
+
+func nextEvent(t *testing.T, events <-chan drivers.Event) (drivers.Event, bool) {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		return e, ok
+	case <-time.After(watchTimeout):
+		t.Fatal("timeout waiting for the event")
+		return drivers.Event{}, false
+	}
+}
+
+// testWatch is skipped if the driver isn't a drivers.Watcher
+// the writes of one Update are in ascending order of keys, so the events of all drivers are in the same order
+func testWatch(t *testing.T, db drivers.KeyValueDB) {
+	watcher, ok := db.(drivers.Watcher)
+	if !ok {
+		t.Skip("the driver doesn't implement drivers.Watcher")
+	}
+
+	events, cancel := watcher.Watch([]byte("b"))
+
+	steps := []func() error{
+		func() error { return db.Upsert(keys[0], valueOf(keys[0])) }, // out of the prefix
+		func() error { return db.Upsert(keys[1], valueOf(keys[1])) },
+		func() error {
+			return db.Update(func(bkt dbtx.Bucket) error {
+				if err := bkt.Delete(keys[1]); err != nil {
+					return err
+				}
+				return bkt.Put(keys[2], valueOf(keys[2]))
+			})
+		},
+		func() error {
+			return db.Update(func(bkt dbtx.Bucket) error {
+				bkt.Put(keys[1], valueOf(keys[1])) // nolint
+				return errQuery
+			})
+		},
+		func() error { return db.Delete(keys[2]) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil && !errors.Is(err, errQuery) {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []drivers.Event{
+		{Op: watch.Put, Key: keys[1], Value: valueOf(keys[1])},
+		{Op: watch.Delete, Key: keys[1]},
+		{Op: watch.Put, Key: keys[2], Value: valueOf(keys[2])},
+		{Op: watch.Delete, Key: keys[2]},
+	}
+	for _, exp := range expected {
+		e, ok := nextEvent(t, events)
+		if !ok {
+			t.Fatal("the channel was closed")
+		}
+		if e.Op != exp.Op || !bytes.Equal(e.Key, exp.Key) || !bytes.Equal(e.Value, exp.Value) {
+			t.Errorf("expected %s %q=%q, got %s %q=%q", exp.Op, exp.Key, exp.Value, e.Op, e.Key, e.Value)
+		}
+	}
+
+	cancel()
+	if e, ok := nextEvent(t, events); ok {
+		t.Errorf("unexpected event after cancel: %s %q", e.Op, e.Key)
+	}
+}
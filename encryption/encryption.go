@@ -0,0 +1,429 @@
+/*
+	Package encryption implements a drivers.KeyValueDB that encrypts the values
+	of any other driver with AES-GCM.
+	Each value has a header with the ID of the key used to encrypt it, so many
+	keys can be active and the values can be rotated to a new key while the
+	database is used. The stored key and the header are authenticated with the
+	value, so a value copied to another key can't be decrypted.
+	The keys can be encrypted too, in a deterministic way, so the lookups by key
+	still work, but the order of the keys in the iterations is lost.
+*/
+
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+var (
+	// ErrUnknownKey is returned when the value was encrypted with a key that isn't in the keyring
+	ErrUnknownKey = errors.New("encryption: unknown key id")
+	// ErrNoPrimaryKey is returned when a value is written without a primary key in the keyring
+	ErrNoPrimaryKey = errors.New("encryption: keyring without primary key")
+	// ErrCorrupted is returned when the value or key can't be decrypted
+	ErrCorrupted = errors.New("encryption: corrupted data")
+)
+
+// header length: key id
+const headerLen = 4
+
+// RotateBatch is the amount of values re-encrypted in each transaction of Rotate
+var RotateBatch = 1000
+
+// pageSize of the scans of ForEach
+var pageSize = 256
+
+// newAEAD returns the AES-GCM of the key, it must have 16, 24 or 32 bytes
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption: %s", err.Error())
+	}
+	return cipher.NewGCM(block)
+}
+
+// Keyring has the active keys by ID
+// the primary key encrypts the new values, the others only decrypt
+type Keyring struct {
+	keys    map[uint32]cipher.AEAD
+	primary uint32
+	sync.RWMutex
+}
+
+// NewKeyring returns an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32]cipher.AEAD)}
+}
+
+// Add the key with the id, the first key added is the primary
+func (kr *Keyring) Add(id uint32, key []byte) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	kr.Lock()
+	defer kr.Unlock()
+	if len(kr.keys) == 0 {
+		kr.primary = id
+	}
+	kr.keys[id] = aead
+	return nil
+}
+
+// SetPrimary changes the key used to encrypt the new values
+func (kr *Keyring) SetPrimary(id uint32) error {
+	kr.Lock()
+	defer kr.Unlock()
+	if _, ok := kr.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	kr.primary = id
+	return nil
+}
+
+// Primary returns the id of the primary key
+func (kr *Keyring) Primary() uint32 {
+	kr.RLock()
+	defer kr.RUnlock()
+	return kr.primary
+}
+
+// Remove the key, the values encrypted with it can't be read anymore
+func (kr *Keyring) Remove(id uint32) {
+	kr.Lock()
+	defer kr.Unlock()
+	delete(kr.keys, id)
+}
+
+// additionalData authenticated with the value: stored key | header
+func additionalData(key, header []byte) []byte {
+	return append(append(make([]byte, 0, len(key)+headerLen), key...), header[:headerLen]...)
+}
+
+// seal encrypts the value of the stored key with the primary key
+// format: key id | nonce | ciphertext
+func (kr *Keyring) seal(key, value []byte) ([]byte, error) {
+	kr.RLock()
+	id := kr.primary
+	aead, ok := kr.keys[id]
+	kr.RUnlock()
+	if !ok {
+		return nil, ErrNoPrimaryKey
+	}
+
+	out := make([]byte, headerLen+aead.NonceSize(), headerLen+aead.NonceSize()+len(value)+aead.Overhead())
+	binary.BigEndian.PutUint32(out, id)
+	nonce := out[headerLen:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, value, additionalData(key, out)), nil
+}
+
+// keyID returns the id of the key used to encrypt the value
+func keyID(sealed []byte) (uint32, error) {
+	if len(sealed) < headerLen {
+		return 0, ErrCorrupted
+	}
+	return binary.BigEndian.Uint32(sealed), nil
+}
+
+// open decrypts the value of the stored key with the key of the header
+func (kr *Keyring) open(key, sealed []byte) ([]byte, error) {
+	id, err := keyID(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	kr.RLock()
+	aead, ok := kr.keys[id]
+	kr.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, id)
+	}
+
+	data := sealed[headerLen:]
+	if len(data) < aead.NonceSize() {
+		return nil, ErrCorrupted
+	}
+	value, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData(key, sealed))
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return value, nil
+}
+
+// Options of the encryption
+// KeyEncryption: key of 16, 24 or 32 bytes used to encrypt the keys, nil keeps the keys in plain
+// the key encryption key isn't rotated
+type Options struct {
+	KeyEncryption []byte
+}
+
+// DB encrypts the values of the wrapped database
+type DB struct {
+	drivers.KeyValueDB
+	keyring *Keyring
+	kek     cipher.AEAD // nil if the keys are not encrypted
+	mac     []byte
+	mu      sync.RWMutex // Rotate locks the writes while a batch is rotated
+}
+
+// New wraps the database
+func New(db drivers.KeyValueDB, keyring *Keyring, opts Options) (*DB, error) {
+	edb := &DB{KeyValueDB: db, keyring: keyring}
+	if opts.KeyEncryption != nil {
+		kek, err := newAEAD(opts.KeyEncryption)
+		if err != nil {
+			return nil, err
+		}
+		edb.kek = kek
+		// the nonces of the keys are derived from a different key
+		mac := hmac.New(sha256.New, opts.KeyEncryption)
+		mac.Write([]byte("nonce")) // nolint
+		edb.mac = mac.Sum(nil)
+	}
+	return edb, nil
+}
+
+// nonce of the key, the same key always has the same nonce
+func (db *DB) nonce(key []byte) []byte {
+	mac := hmac.New(sha256.New, db.mac)
+	mac.Write(key) // nolint
+	return mac.Sum(nil)[:db.kek.NonceSize()]
+}
+
+// encryptKey returns the key stored in the database
+func (db *DB) encryptKey(key []byte) []byte {
+	if db.kek == nil {
+		return key
+	}
+	nonce := db.nonce(key)
+	return db.kek.Seal(nonce, nonce, key, nil)
+}
+
+// decryptKey returns the key used by the caller
+func (db *DB) decryptKey(stored []byte) ([]byte, error) {
+	if db.kek == nil {
+		return stored, nil
+	}
+	size := db.kek.NonceSize()
+	if len(stored) < size {
+		return nil, ErrCorrupted
+	}
+	key, err := db.kek.Open(nil, stored[:size], stored[size:], nil)
+	if err != nil || !hmac.Equal(stored[:size], db.nonce(key)) {
+		return nil, ErrCorrupted
+	}
+	return key, nil
+}
+
+// Get decrypts the value
+func (db *DB) Get(key []byte) ([]byte, error) {
+	stored := db.encryptKey(key)
+	sealed, err := db.KeyValueDB.Get(stored)
+	if err != nil {
+		return nil, err
+	}
+	return db.keyring.open(stored, sealed)
+}
+
+// ForEach decrypts the values before the query
+// the values are read with their keys, by pages of the wrapped database
+func (db *DB) ForEach(query func([]byte) error) error {
+	opts := drivers.ScanOptions{Limit: pageSize}
+	for {
+		page, err := drivers.Scan(db.KeyValueDB, opts)
+		if err != nil {
+			return err
+		}
+		for _, kv := range page.Items {
+			value, err := db.keyring.open(kv.Key, kv.Value)
+			if err != nil {
+				return err
+			}
+			if err := query(value); err != nil {
+				return err
+			}
+		}
+		if page.Token == "" {
+			return nil
+		}
+		opts.Token = page.Token
+	}
+}
+
+// KeyIterator decrypts the keys before the query
+func (db *DB) KeyIterator(query func([]byte) error) error {
+	return db.KeyValueDB.KeyIterator(func(stored []byte) error {
+		key, err := db.decryptKey(stored)
+		if err != nil {
+			return err
+		}
+		return query(key)
+	})
+}
+
+// Upsert encrypts the value with the primary key
+func (db *DB) Upsert(key, value []byte) error {
+	stored := db.encryptKey(key)
+	sealed, err := db.keyring.seal(stored, value)
+	if err != nil {
+		return err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.KeyValueDB.Upsert(stored, sealed)
+}
+
+// Delete the key/value
+func (db *DB) Delete(key []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.KeyValueDB.Delete(db.encryptKey(key))
+}
+
+// Update encrypts the values of the bucket
+func (db *DB) Update(execute dbtx.Execute) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.KeyValueDB.Update(func(bkt dbtx.Bucket) error {
+		return execute(dbtx.BucketImp{
+			PutImp: func(key []byte, value []byte) error {
+				stored := db.encryptKey(key)
+				sealed, err := db.keyring.seal(stored, value)
+				if err != nil {
+					return err
+				}
+				return bkt.Put(stored, sealed)
+			},
+			DeleteImp: func(key []byte) error {
+				return bkt.Delete(db.encryptKey(key))
+			},
+		})
+	})
+}
+
+// stored transforms of the conditional writes of the key
+func (db *DB) stored(key []byte) drivers.Stored {
+	stored := db.encryptKey(key)
+	return drivers.Stored{
+		Key: func([]byte) []byte { return stored },
+		Decode: func(sealed []byte) ([]byte, error) {
+			return db.keyring.open(stored, sealed)
+		},
+		Encode: func(value []byte) ([]byte, error) {
+			return db.keyring.seal(stored, value)
+		},
+	}
+}
+
+// CompareAndSwap compares the decrypted value and swaps the encrypted one
+func (db *DB) CompareAndSwap(key, expected, value []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.stored(key).CompareAndSwap(db.KeyValueDB, key, expected, value)
+}
+
+// PutIfAbsent encrypts the value if the key doesn't exist
+func (db *DB) PutIfAbsent(key, value []byte) error {
+	return db.CompareAndSwap(key, nil, value)
+}
+
+// Increment the decrypted counter
+func (db *DB) Increment(key []byte, delta int64) (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.stored(key).Increment(db.KeyValueDB, key, delta)
+}
+
+// Rotate re-encrypts with the primary key all values of the current bucket
+// encrypted with other keys, it returns the amount of rotated values
+// the database can be used while it's running, the writes wait only for the current batch
+func (db *DB) Rotate() (int, error) {
+	var keys [][]byte
+	if err := db.KeyValueDB.KeyIterator(func(stored []byte) error {
+		keys = append(keys, stored)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	rotated := 0
+	for start := 0; start < len(keys); start += RotateBatch {
+		end := start + RotateBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		n, err := db.rotate(keys[start:end])
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, nil
+}
+
+// rotate the values of the stored keys in one transaction
+func (db *DB) rotate(keys [][]byte) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	primary := db.keyring.Primary()
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		sealed, err := db.KeyValueDB.Get(key)
+		if errors.Is(err, drivers.ErrNotFound) {
+			continue // deleted after the iteration
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		if id, err := keyID(sealed); err != nil {
+			return 0, err
+		} else if id == primary {
+			continue
+		}
+
+		value, err := db.keyring.open(key, sealed)
+		if err != nil {
+			return 0, err
+		}
+		if values[string(key)], err = db.keyring.seal(key, value); err != nil {
+			return 0, err
+		}
+	}
+
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	err := db.KeyValueDB.Update(func(bkt dbtx.Bucket) error {
+		for key, sealed := range values {
+			if err := bkt.Put([]byte(key), sealed); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(values), nil
+}
@@ -0,0 +1,208 @@
+package encryption_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/drivertest"
+	"github.com/plateausnetwork/drivers/encryption"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+var key = []byte("key")
+var value = []byte("wallet value")
+var testBucket = []byte("tbucket")
+
+var key1 = bytes.Repeat([]byte{1}, 32)
+var key2 = bytes.Repeat([]byte{2}, 32)
+var kek = bytes.Repeat([]byte{3}, 16)
+
+func openBolt(dir string) (drivers.KeyValueDB, error) {
+	opts := drivers.DriverOptions()
+	opts.AddBucket(testBucket)
+	return drivers.Open(drivers.Boltdb, dir+"/test.db", opts)
+}
+
+func newKeyring() *encryption.Keyring {
+	keyring := encryption.NewKeyring()
+	if err := keyring.Add(1, key1); err != nil {
+		panic(err)
+	}
+	return keyring
+}
+
+func withEncryption(opts encryption.Options, handler func(*encryption.DB, *encryption.Keyring)) {
+	runners.WithTempDir(func(dir string) {
+		db, err := openBolt(dir)
+		if err != nil {
+			panic(err)
+		}
+		defer db.Close()
+
+		keyring := newKeyring()
+		edb, err := encryption.New(db, keyring, opts)
+		if err != nil {
+			panic(err)
+		}
+		handler(edb, keyring)
+	})
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformance(t, func(dir string) (drivers.KeyValueDB, error) {
+		db, err := openBolt(dir)
+		if err != nil {
+			return nil, err
+		}
+		return encryption.New(db, newKeyring(), encryption.Options{})
+	}, drivertest.Options{Buckets: true})
+}
+
+func TestEncryption(t *testing.T) {
+	withEncryption(encryption.Options{}, func(db *encryption.DB, _ *encryption.Keyring) {
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := db.KeyValueDB.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(stored, value) {
+			t.Error("the stored value must be encrypted")
+		}
+
+		if v, err := db.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Errorf("the value is different than expected: %v", err)
+		}
+	})
+}
+
+// the values are bound to their keys
+func TestSwappedValue(t *testing.T) {
+	withEncryption(encryption.Options{}, func(db *encryption.DB, _ *encryption.Keyring) {
+		other := []byte("other")
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Upsert(other, []byte("other value")); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := db.KeyValueDB.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.KeyValueDB.Upsert(other, stored); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Get(other); !errors.Is(err, encryption.ErrCorrupted) {
+			t.Errorf("expected ErrCorrupted, got %v", err)
+		}
+		if err := db.ForEach(func([]byte) error { return nil }); !errors.Is(err, encryption.ErrCorrupted) {
+			t.Errorf("expected ErrCorrupted in the iteration, got %v", err)
+		}
+	})
+}
+
+func TestKeyEncryption(t *testing.T) {
+	withEncryption(encryption.Options{KeyEncryption: kek}, func(db *encryption.DB, _ *encryption.Keyring) {
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := db.KeyValueDB.Get(key); !errors.Is(err, drivers.ErrNotFound) {
+			t.Error("the stored key must be encrypted")
+		}
+
+		if v, err := db.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Errorf("the value is different than expected: %v", err)
+		}
+
+		var keys [][]byte
+		if err := db.KeyIterator(func(k []byte) error {
+			keys = append(keys, k)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 1 || !bytes.Equal(keys[0], key) {
+			t.Errorf("the iterator must return the decrypted keys: %q", keys)
+		}
+
+		if err := db.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+		if db.Length() != 0 {
+			t.Error("the key must be deleted")
+		}
+	})
+}
+
+func TestRotate(t *testing.T) {
+	withEncryption(encryption.Options{}, func(db *encryption.DB, keyring *encryption.Keyring) {
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := keyring.Add(2, key2); err != nil {
+			t.Fatal(err)
+		}
+		if err := keyring.SetPrimary(2); err != nil {
+			t.Fatal(err)
+		}
+
+		// written with the new primary key
+		if err := db.Upsert([]byte("k2"), value); err != nil {
+			t.Fatal(err)
+		}
+
+		rotated, err := db.Rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated != 1 {
+			t.Errorf("expected 1 rotated value, received %d", rotated)
+		}
+
+		// the old key isn't needed anymore
+		keyring.Remove(1)
+		if v, err := db.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Errorf("the value is different than expected: %v", err)
+		}
+	})
+}
+
+func TestUnknownKey(t *testing.T) {
+	withEncryption(encryption.Options{}, func(db *encryption.DB, keyring *encryption.Keyring) {
+		if err := db.Upsert(key, value); err != nil {
+			t.Fatal(err)
+		}
+
+		keyring.Remove(1)
+		if _, err := db.Get(key); !errors.Is(err, encryption.ErrUnknownKey) {
+			t.Errorf("expected %v, received %v", encryption.ErrUnknownKey, err)
+		}
+
+		if err := db.Upsert(key, value); err != encryption.ErrNoPrimaryKey {
+			t.Errorf("expected %v, received %v", encryption.ErrNoPrimaryKey, err)
+		}
+
+		if err := keyring.SetPrimary(5); err != encryption.ErrUnknownKey {
+			t.Errorf("expected %v, received %v", encryption.ErrUnknownKey, err)
+		}
+	})
+}
+
+// the conditional writes use the encrypted keys
+func TestConditionalKeyEncryption(t *testing.T) {
+	drivertest.RunConditional(t, func(dir string) (drivers.KeyValueDB, error) {
+		db, err := openBolt(dir)
+		if err != nil {
+			return nil, err
+		}
+		return encryption.New(db, newKeyring(), encryption.Options{KeyEncryption: kek})
+	})
+}
@@ -4,7 +4,7 @@ go 1.13
 
 require (
 	github.com/dgraph-io/badger v1.6.1
-	github.com/dgraph-io/ristretto v0.0.2
+	github.com/dgraph-io/ristretto v0.1.0
 	github.com/google/uuid v1.1.1
 	github.com/plateausnetwork/fs v1.0.1
 	github.com/rhizomplatform/fs v1.0.0
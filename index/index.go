@@ -0,0 +1,350 @@
+/*
+	Package index maintains secondary indexes of a drivers.KeyValueDB.
+	The primary key/values are stored as they are and each index entry is stored
+	in the same database, with a reserved prefix, in the same transaction of the
+	primary write.
+	All writes must be done by the Manager, so it knows the old values and
+	removes the stale index entries.
+*/
+
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Prefix of the index keys, the primary keys must not start with it
+var Prefix = []byte("\xffidx\x00")
+
+// ErrUnknownIndex is returned by Lookup when the index isn't declared
+var ErrUnknownIndex = errors.New("index: unknown index")
+
+// Func returns the index values of the key/value
+// the key/value can be found by any of the returned index values
+type Func func(key, value []byte) [][]byte
+
+// IsIndexKey returns true if the key is an index entry
+// it helps to skip the index entries when iterating in the database
+func IsIndexKey(key []byte) bool {
+	return bytes.HasPrefix(key, Prefix)
+}
+
+// indexKey: prefix | name | 0x00 | index value
+func indexKey(name string, value []byte) []byte {
+	key := make([]byte, 0, len(Prefix)+len(name)+1+len(value))
+	key = append(key, Prefix...)
+	key = append(key, name...)
+	key = append(key, 0)
+	return append(key, value...)
+}
+
+// encodeKeys encodes the sorted list of primary keys of an index entry
+func encodeKeys(keys [][]byte) []byte {
+	var buf []byte
+	var size [binary.MaxVarintLen64]byte
+	for _, k := range keys {
+		n := binary.PutUvarint(size[:], uint64(len(k)))
+		buf = append(buf, size[:n]...)
+		buf = append(buf, k...)
+	}
+	return buf
+}
+
+// decodeKeys decodes the list of primary keys of an index entry
+func decodeKeys(buf []byte) ([][]byte, error) {
+	var keys [][]byte
+	for len(buf) > 0 {
+		size, n := binary.Uvarint(buf)
+		if n <= 0 || uint64(len(buf)-n) < size {
+			return nil, fmt.Errorf("index: corrupted entry")
+		}
+		keys = append(keys, buf[n:n+int(size)])
+		buf = buf[n+int(size):]
+	}
+	return keys, nil
+}
+
+// Manager of the indexes of the database
+type Manager struct {
+	db      drivers.KeyValueDB
+	indexes map[string]Func
+	sync.Mutex
+}
+
+// New returns the manager without indexes
+func New(db drivers.KeyValueDB) *Manager {
+	return &Manager{db: db, indexes: make(map[string]Func)}
+}
+
+// AddIndex declares the index, the existent key/values are indexed only by Rebuild
+func (m *Manager) AddIndex(name string, fn Func) error {
+	if name == "" || strings.IndexByte(name, 0) >= 0 {
+		return fmt.Errorf("index: invalid name %q", name)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	if _, ok := m.indexes[name]; ok {
+		return fmt.Errorf("index: duplicated index %q", name)
+	}
+	m.indexes[name] = fn
+	return nil
+}
+
+// Get the primary value
+func (m *Manager) Get(key []byte) ([]byte, error) {
+	return m.db.Get(key)
+}
+
+// Lookup returns the primary keys with the index value
+func (m *Manager) Lookup(name string, value []byte) ([][]byte, error) {
+	m.Lock()
+	_, ok := m.indexes[name]
+	m.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownIndex, name)
+	}
+
+	buf, err := m.db.Get(indexKey(name, value))
+	if errors.Is(err, drivers.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeys(buf)
+}
+
+// Put the primary key/value and its index entries
+func (m *Manager) Put(key, value []byte) error {
+	return m.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, value)
+	})
+}
+
+// Delete the primary key/value and its index entries
+func (m *Manager) Delete(key []byte) error {
+	return m.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Delete(key)
+	})
+}
+
+// Update executes the writes of primary key/values in one transaction
+// the index entries are changed in the same transaction
+func (m *Manager) Update(execute dbtx.Execute) error {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.db.Update(func(bkt dbtx.Bucket) error {
+		tx := &txn{
+			manager: m,
+			bucket:  bkt,
+			values:  make(map[string][]byte),
+			entries: make(map[string][][]byte),
+		}
+		return execute(dbtx.BucketImp{PutImp: tx.put, DeleteImp: tx.delete})
+	})
+}
+
+// Rebuild deletes all index entries and indexes all key/values again
+func (m *Manager) Rebuild() error {
+	m.Lock()
+	defer m.Unlock()
+
+	var indexKeys, keys [][]byte
+	if err := m.db.KeyIterator(func(k []byte) error {
+		if IsIndexKey(k) {
+			indexKeys = append(indexKeys, k)
+		} else {
+			keys = append(keys, k)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	entries := make(map[string][][]byte)
+	for _, key := range keys {
+		value, err := m.db.Get(key)
+		if err != nil {
+			return err
+		}
+		for name, fn := range m.indexes {
+			for _, v := range fn(key, value) {
+				ik := string(indexKey(name, v))
+				entries[ik] = insert(entries[ik], key)
+			}
+		}
+	}
+
+	return m.db.Update(func(bkt dbtx.Bucket) error {
+		for _, k := range indexKeys {
+			if err := bkt.Delete(k); err != nil {
+				return err
+			}
+		}
+		for k, list := range entries {
+			if err := bkt.Put([]byte(k), encodeKeys(list)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insert the key in the sorted list, if it isn't there
+func insert(list [][]byte, key []byte) [][]byte {
+	i := sort.Search(len(list), func(i int) bool { return bytes.Compare(list[i], key) >= 0 })
+	if i < len(list) && bytes.Equal(list[i], key) {
+		return list
+	}
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = key
+	return list
+}
+
+// remove the key from the sorted list
+func remove(list [][]byte, key []byte) [][]byte {
+	i := sort.Search(len(list), func(i int) bool { return bytes.Compare(list[i], key) >= 0 })
+	if i < len(list) && bytes.Equal(list[i], key) {
+		return append(list[:i], list[i+1:]...)
+	}
+	return list
+}
+
+// txn keeps the changes of one transaction
+// values: primary values written, nil if deleted
+// entries: index entries written
+type txn struct {
+	manager *Manager
+	bucket  dbtx.Bucket
+	values  map[string][]byte
+	entries map[string][][]byte
+}
+
+// value returns the current primary value, nil if it doesn't exist
+func (tx *txn) value(key []byte) ([]byte, error) {
+	if v, ok := tx.values[string(key)]; ok {
+		return v, nil
+	}
+	v, err := tx.manager.db.Get(key)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the existence is given by the error, badger returns nil for the empty values
+	return append([]byte{}, v...), nil
+}
+
+// entry returns the current list of primary keys of the index entry
+func (tx *txn) entry(key []byte) ([][]byte, error) {
+	if list, ok := tx.entries[string(key)]; ok {
+		return list, nil
+	}
+	buf, err := tx.manager.db.Get(key)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeys(buf)
+}
+
+// reindex changes the index entries from the old to the new value
+// a nil value means the key/value doesn't exist
+func (tx *txn) reindex(key, old, value []byte) error {
+	for name, fn := range tx.manager.indexes {
+		oldValues := make(map[string]bool)
+		if old != nil {
+			for _, v := range fn(key, old) {
+				oldValues[string(v)] = true
+			}
+		}
+
+		newValues := make(map[string]bool)
+		if value != nil {
+			for _, v := range fn(key, value) {
+				newValues[string(v)] = true
+			}
+		}
+
+		for v := range oldValues {
+			if !newValues[v] {
+				if err := tx.change(indexKey(name, []byte(v)), key, remove); err != nil {
+					return err
+				}
+			}
+		}
+		for v := range newValues {
+			if !oldValues[v] {
+				if err := tx.change(indexKey(name, []byte(v)), key, insert); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// change the list of the index entry, the empty lists are deleted
+func (tx *txn) change(ik, key []byte, fn func([][]byte, []byte) [][]byte) error {
+	list, err := tx.entry(ik)
+	if err != nil {
+		return err
+	}
+
+	list = fn(list, append([]byte{}, key...))
+	tx.entries[string(ik)] = list
+	if len(list) == 0 {
+		return tx.bucket.Delete(ik)
+	}
+	return tx.bucket.Put(ik, encodeKeys(list))
+}
+
+func (tx *txn) put(key, value []byte) error {
+	if IsIndexKey(key) {
+		return fmt.Errorf("index: the key %q uses the reserved prefix", key)
+	}
+
+	old, err := tx.value(key)
+	if err != nil {
+		return err
+	}
+	if err := tx.bucket.Put(key, value); err != nil {
+		return err
+	}
+
+	// the empty value is stored as not nil, nil means deleted
+	value = append([]byte{}, value...)
+	tx.values[string(key)] = value
+	return tx.reindex(key, old, value)
+}
+
+func (tx *txn) delete(key []byte) error {
+	old, err := tx.value(key)
+	if err != nil {
+		return err
+	}
+	if err := tx.bucket.Delete(key); err != nil {
+		return err
+	}
+
+	tx.values[string(key)] = nil
+	if old == nil {
+		return nil
+	}
+	return tx.reindex(key, old, nil)
+}
@@ -0,0 +1,207 @@
+package index_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/index"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+var testBucket = []byte("tbucket")
+
+// transactions are stored as "from:to"
+func byAddress(key, value []byte) [][]byte {
+	return bytes.Split(value, []byte(":"))
+}
+
+func withManager(handler func(*index.Manager, drivers.KeyValueDB)) {
+	runners.WithTempDir(func(dir string) {
+		opts := drivers.DriverOptions()
+		opts.AddBucket(testBucket)
+		db, err := drivers.Open(drivers.Boltdb, dir+"/test.db", opts)
+		if err != nil {
+			panic(err)
+		}
+		defer db.Close()
+
+		manager := index.New(db)
+		if err := manager.AddIndex("address", byAddress); err != nil {
+			panic(err)
+		}
+		handler(manager, db)
+	})
+}
+
+func expectLookup(t *testing.T, m *index.Manager, address string, expected ...string) {
+	t.Helper()
+	keys, err := m.Lookup("address", []byte(address))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%s", keys) != fmt.Sprintf("%s", expected) {
+		t.Errorf("lookup %q: expected %s, received %s", address, expected, keys)
+	}
+}
+
+func TestPut(t *testing.T) {
+	withManager(func(m *index.Manager, _ drivers.KeyValueDB) {
+		if err := m.Put([]byte("tx1"), []byte("alice:bob")); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Put([]byte("tx2"), []byte("bob:carol")); err != nil {
+			t.Fatal(err)
+		}
+
+		expectLookup(t, m, "alice", "tx1")
+		expectLookup(t, m, "bob", "tx1", "tx2")
+		expectLookup(t, m, "carol", "tx2")
+		expectLookup(t, m, "dave")
+	})
+}
+
+func TestStaleEntries(t *testing.T) {
+	withManager(func(m *index.Manager, db drivers.KeyValueDB) {
+		if err := m.Put([]byte("tx1"), []byte("alice:bob")); err != nil {
+			t.Fatal(err)
+		}
+
+		// update removes the old addresses
+		if err := m.Put([]byte("tx1"), []byte("alice:carol")); err != nil {
+			t.Fatal(err)
+		}
+		expectLookup(t, m, "bob")
+		expectLookup(t, m, "carol", "tx1")
+
+		if err := m.Delete([]byte("tx1")); err != nil {
+			t.Fatal(err)
+		}
+		expectLookup(t, m, "alice")
+		expectLookup(t, m, "carol")
+
+		// the empty entries are deleted
+		if db.Length() != 0 {
+			t.Errorf("the database must be empty, length %d", db.Length())
+		}
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	withManager(func(m *index.Manager, _ drivers.KeyValueDB) {
+		err := m.Update(func(bkt dbtx.Bucket) error {
+			if err := bkt.Put([]byte("tx1"), []byte("alice:bob")); err != nil {
+				return err
+			}
+			if err := bkt.Put([]byte("tx2"), []byte("alice:carol")); err != nil {
+				return err
+			}
+			return bkt.Delete([]byte("tx1"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectLookup(t, m, "alice", "tx2")
+		expectLookup(t, m, "bob")
+
+		// the failed transaction changes nothing
+		err = m.Update(func(bkt dbtx.Bucket) error {
+			if err := bkt.Put([]byte("tx3"), []byte("alice:dave")); err != nil {
+				return err
+			}
+			return fmt.Errorf("test error")
+		})
+		if err == nil {
+			t.Error("the error of the execution must be returned")
+		}
+		expectLookup(t, m, "alice", "tx2")
+		expectLookup(t, m, "dave")
+
+		if err := m.Put([]byte("\xffidx\x00x"), nil); err == nil {
+			t.Error("the reserved prefix must not be used by primary keys")
+		}
+	})
+}
+
+func TestRebuild(t *testing.T) {
+	withManager(func(m *index.Manager, db drivers.KeyValueDB) {
+		// written without the manager
+		if err := db.Upsert([]byte("tx1"), []byte("alice:bob")); err != nil {
+			t.Fatal(err)
+		}
+		expectLookup(t, m, "alice")
+
+		if err := m.Rebuild(); err != nil {
+			t.Fatal(err)
+		}
+		expectLookup(t, m, "alice", "tx1")
+
+		// a new index is built from the existent key/values
+		upper := func(key, value []byte) [][]byte {
+			return [][]byte{[]byte(strings.ToUpper(string(value)))}
+		}
+		if err := m.AddIndex("upper", upper); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Rebuild(); err != nil {
+			t.Fatal(err)
+		}
+		keys, err := m.Lookup("upper", []byte("ALICE:BOB"))
+		if err != nil || len(keys) != 1 {
+			t.Errorf("the new index must be rebuilt: %s %v", keys, err)
+		}
+	})
+}
+
+func TestInvalidIndex(t *testing.T) {
+	withManager(func(m *index.Manager, _ drivers.KeyValueDB) {
+		if _, err := m.Lookup("inexistent", nil); !errors.Is(err, index.ErrUnknownIndex) {
+			t.Errorf("expected %v, received %v", index.ErrUnknownIndex, err)
+		}
+
+		if err := m.AddIndex("address", byAddress); err == nil {
+			t.Error("duplicated index must return an error")
+		}
+
+		if err := m.AddIndex("", byAddress); err == nil {
+			t.Error("empty name must return an error")
+		}
+	})
+}
+
+func TestEmptyValue(t *testing.T) {
+	// badger returns nil for the empty values
+	runners.WithTempDir(func(dir string) {
+		db, err := drivers.Open(drivers.Badgerdb, dir, drivers.DriverOptions())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		m := index.New(db)
+		if err := m.AddIndex("all", func(key, value []byte) [][]byte {
+			return [][]byte{[]byte("all")}
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range []string{"tx1", "tx2"} {
+			if err := m.Put([]byte(key), []byte{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.Delete([]byte("tx1")); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Put([]byte("tx2"), []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if keys, err := m.Lookup("all", []byte("all")); err != nil || fmt.Sprintf("%s", keys) != "[tx2]" {
+			t.Errorf("expected [tx2], received %s: %v", keys, err)
+		}
+	})
+}
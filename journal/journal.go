@@ -0,0 +1,514 @@
+/*
+	Package journal implements a drivers.KeyValueDB that records, for each
+	checkpoint, the prior value of every key written after it, so the writes
+	can be undone back to a checkpoint, like in a chain reorganization.
+	The journal is stored in the same database, with a reserved prefix, in the
+	same transaction of the writes. The iterations and Length of the Journal
+	skip the keys of the journal.
+*/
+
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Prefix of the journal keys, the key/values must not start with it
+var Prefix = []byte("\xffjnl\x00")
+
+var (
+	// ErrUnknownCheckpoint is returned when the checkpoint doesn't exist or was pruned
+	ErrUnknownCheckpoint = errors.New("journal: unknown checkpoint")
+	// ErrInvalidCheckpoint is returned when a checkpoint isn't greater than the last one
+	ErrInvalidCheckpoint = errors.New("journal: checkpoint must be greater than the last one")
+)
+
+// flags of the prior values
+const (
+	priorAbsent byte = iota
+	priorValue
+)
+
+// keys of the journal
+// checkpoints: ids of the checkpoints, the last one receives the writes
+// count: amount of entries of the checkpoint
+// entry: prior value of a key written after the checkpoint
+var checkpointsKey = journalKey("checkpoints")
+
+func journalKey(name string, ids ...uint64) []byte {
+	key := append(append([]byte{}, Prefix...), name...)
+	for _, id := range ids {
+		var buf [8]byte
+		binary.BigEndian.PutUint64(buf[:], id)
+		key = append(key, buf[:]...)
+	}
+	return key
+}
+
+func countKey(id uint64) []byte {
+	return journalKey("count", id)
+}
+
+func entryKey(id, seq uint64) []byte {
+	return journalKey("entry", id, seq)
+}
+
+// IsJournalKey returns true if the key is used by the journal
+func IsJournalKey(key []byte) bool {
+	return bytes.HasPrefix(key, Prefix)
+}
+
+// entry: key length | key | flag | prior value
+func encodeEntry(key, prior []byte, exists bool) []byte {
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(key)))
+	buf := append(append([]byte{}, size[:n]...), key...)
+	if !exists {
+		return append(buf, priorAbsent)
+	}
+	return append(append(buf, priorValue), prior...)
+}
+
+func decodeEntry(buf []byte) (key, prior []byte, exists bool, err error) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) <= size {
+		return nil, nil, false, fmt.Errorf("journal: corrupted entry")
+	}
+	key = buf[n : n+int(size)]
+	buf = buf[n+int(size):]
+	return key, buf[1:], buf[0] == priorValue, nil
+}
+
+func encodeUint64(v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return buf[:]
+}
+
+func decodeUint64(buf []byte) (uint64, error) {
+	if len(buf) != 8 {
+		return 0, fmt.Errorf("journal: corrupted counter")
+	}
+	return binary.BigEndian.Uint64(buf), nil
+}
+
+// Journal over the writes of the database
+// all writes must be done by the Journal, so the prior values are recorded
+type Journal struct {
+	drivers.KeyValueDB
+	checkpoints []uint64
+	count       uint64              // entries of the last checkpoint
+	touched     map[string]struct{} // keys recorded in the last checkpoint
+	sync.Mutex
+}
+
+// New returns the journal of the database, with the checkpoints stored in it
+func New(db drivers.KeyValueDB) (*Journal, error) {
+	j := &Journal{KeyValueDB: db, touched: make(map[string]struct{})}
+
+	buf, err := db.Get(checkpointsKey)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return j, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for ; len(buf) >= 8; buf = buf[8:] {
+		id, _ := decodeUint64(buf[:8])
+		j.checkpoints = append(j.checkpoints, id)
+	}
+
+	// loads the keys recorded in the last checkpoint
+	if len(j.checkpoints) == 0 {
+		return j, nil
+	}
+	last := j.checkpoints[len(j.checkpoints)-1]
+	if j.count, err = j.entries(last); err != nil {
+		return nil, err
+	}
+	for seq := uint64(0); seq < j.count; seq++ {
+		key, _, _, err := j.entry(last, seq)
+		if err != nil {
+			return nil, err
+		}
+		j.touched[string(key)] = struct{}{}
+	}
+	return j, nil
+}
+
+// Checkpoints returns the ids of the checkpoints available to rollback
+func (j *Journal) Checkpoints() []uint64 {
+	j.Lock()
+	defer j.Unlock()
+	return append([]uint64{}, j.checkpoints...)
+}
+
+// entries returns the amount of entries of the checkpoint
+func (j *Journal) entries(id uint64) (uint64, error) {
+	buf, err := j.KeyValueDB.Get(countKey(id))
+	if errors.Is(err, drivers.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return decodeUint64(buf)
+}
+
+func (j *Journal) entry(id, seq uint64) ([]byte, []byte, bool, error) {
+	buf, err := j.KeyValueDB.Get(entryKey(id, seq))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return decodeEntry(buf)
+}
+
+func encodeCheckpoints(ids []uint64) []byte {
+	buf := make([]byte, 0, 8*len(ids))
+	for _, id := range ids {
+		buf = append(buf, encodeUint64(id)...)
+	}
+	return buf
+}
+
+// Checkpoint starts a new checkpoint, the next writes can be undone by Rollback(id)
+func (j *Journal) Checkpoint(id uint64) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if n := len(j.checkpoints); n > 0 && id <= j.checkpoints[n-1] {
+		return ErrInvalidCheckpoint
+	}
+
+	checkpoints := append(append([]uint64{}, j.checkpoints...), id)
+	if err := j.KeyValueDB.Upsert(checkpointsKey, encodeCheckpoints(checkpoints)); err != nil {
+		return err
+	}
+
+	j.checkpoints = checkpoints
+	j.count = 0
+	j.touched = make(map[string]struct{})
+	return nil
+}
+
+// index of the checkpoint in the list
+func (j *Journal) find(id uint64) (int, error) {
+	for i, c := range j.checkpoints {
+		if c == id {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %d", ErrUnknownCheckpoint, id)
+}
+
+// Rollback restores the database to the state of the checkpoint
+// the checkpoint is kept and receives the next writes, the later ones are removed
+func (j *Journal) Rollback(id uint64) error {
+	j.Lock()
+	defer j.Unlock()
+
+	pos, err := j.find(id)
+	if err != nil {
+		return err
+	}
+
+	err = j.KeyValueDB.Update(func(bkt dbtx.Bucket) error {
+		// undo from the last entry of the last checkpoint
+		for i := len(j.checkpoints) - 1; i >= pos; i-- {
+			cp := j.checkpoints[i]
+			count, err := j.entries(cp)
+			if err != nil {
+				return err
+			}
+
+			for seq := count; seq > 0; seq-- {
+				key, prior, exists, err := j.entry(cp, seq-1)
+				if err != nil {
+					return err
+				}
+				if exists {
+					err = bkt.Put(key, prior)
+				} else {
+					err = bkt.Delete(key)
+				}
+				if err != nil {
+					return err
+				}
+				if err := bkt.Delete(entryKey(cp, seq-1)); err != nil {
+					return err
+				}
+			}
+			if err := bkt.Delete(countKey(cp)); err != nil {
+				return err
+			}
+		}
+		return bkt.Put(checkpointsKey, encodeCheckpoints(j.checkpoints[:pos+1]))
+	})
+	if err != nil {
+		return err
+	}
+
+	j.checkpoints = j.checkpoints[:pos+1]
+	j.count = 0
+	j.touched = make(map[string]struct{})
+	return nil
+}
+
+// Prune removes the checkpoints before the id, they can't be used by Rollback anymore
+func (j *Journal) Prune(id uint64) error {
+	j.Lock()
+	defer j.Unlock()
+
+	pos := 0
+	for pos < len(j.checkpoints) && j.checkpoints[pos] < id {
+		pos++
+	}
+	if pos == 0 {
+		return nil
+	}
+
+	err := j.KeyValueDB.Update(func(bkt dbtx.Bucket) error {
+		for _, cp := range j.checkpoints[:pos] {
+			count, err := j.entries(cp)
+			if err != nil {
+				return err
+			}
+			for seq := uint64(0); seq < count; seq++ {
+				if err := bkt.Delete(entryKey(cp, seq)); err != nil {
+					return err
+				}
+			}
+			if err := bkt.Delete(countKey(cp)); err != nil {
+				return err
+			}
+		}
+		return bkt.Put(checkpointsKey, encodeCheckpoints(j.checkpoints[pos:]))
+	})
+	if err != nil {
+		return err
+	}
+
+	if pos == len(j.checkpoints) {
+		// the writes aren't recorded without checkpoints
+		j.count = 0
+		j.touched = make(map[string]struct{})
+	}
+	j.checkpoints = append([]uint64{}, j.checkpoints[pos:]...)
+	return nil
+}
+
+// pageSize of the scans of ForEach
+var pageSize = 256
+
+// KeyIterator iterates the keys of the database, without the keys of the journal
+func (j *Journal) KeyIterator(query func([]byte) error) error {
+	return j.KeyValueDB.KeyIterator(func(key []byte) error {
+		if IsJournalKey(key) {
+			return nil
+		}
+		return query(key)
+	})
+}
+
+// ForEach value of the database, without the values of the journal
+func (j *Journal) ForEach(query func([]byte) error) error {
+	opts := drivers.ScanOptions{Limit: pageSize}
+	for {
+		page, err := drivers.Scan(j.KeyValueDB, opts)
+		if err != nil {
+			return err
+		}
+		for _, kv := range page.Items {
+			if IsJournalKey(kv.Key) {
+				continue
+			}
+			if err := query(kv.Value); err != nil {
+				return err
+			}
+		}
+		if page.Token == "" {
+			return nil
+		}
+		opts.Token = page.Token
+	}
+}
+
+// Length amount of keys of the database, without the keys of the journal
+func (j *Journal) Length() int {
+	n, err := drivers.CountPrefix(j.KeyValueDB, Prefix)
+	if err != nil {
+		return j.KeyValueDB.Length()
+	}
+	return j.KeyValueDB.Length() - n
+}
+
+// Clean deletes all key/values in one Update, so it can be undone by Rollback
+// the checkpoints are kept
+func (j *Journal) Clean() {
+	j.Lock()
+	defer j.Unlock()
+
+	var keys [][]byte
+	if err := j.KeyIterator(func(key []byte) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return
+	}
+	j.update(func(bkt dbtx.Bucket) error { //nolint:errcheck
+		for _, key := range keys {
+			if err := bkt.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Upsert records the prior value and updates the key/value
+func (j *Journal) Upsert(key, value []byte) error {
+	return j.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, value)
+	})
+}
+
+// Delete records the prior value and deletes the key/value
+func (j *Journal) Delete(key []byte) error {
+	return j.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Delete(key)
+	})
+}
+
+// Update records the prior values in the same transaction of the writes
+func (j *Journal) Update(execute dbtx.Execute) error {
+	j.Lock()
+	defer j.Unlock()
+	return j.update(execute)
+}
+
+// current returns the value of the key and false if it doesn't exist
+func (j *Journal) current(key []byte) ([]byte, bool, error) {
+	value, err := j.KeyValueDB.Get(key)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// CompareAndSwap records the prior value and writes the value if the current one is the expected
+// the writes of the journal are serialized, so the check and the write are atomic
+func (j *Journal) CompareAndSwap(key, expected, value []byte) error {
+	j.Lock()
+	defer j.Unlock()
+
+	current, found, err := j.current(key)
+	if err != nil {
+		return err
+	}
+	if err := dbtx.CheckSwap(key, current, found, expected); err != nil {
+		return err
+	}
+	return j.update(func(bkt dbtx.Bucket) error {
+		if value == nil {
+			return bkt.Delete(key)
+		}
+		return bkt.Put(key, value)
+	})
+}
+
+// PutIfAbsent records and writes the key/value if the key doesn't exist
+func (j *Journal) PutIfAbsent(key, value []byte) error {
+	return j.CompareAndSwap(key, nil, value)
+}
+
+// Increment records the prior value and adds delta to the counter of the key
+func (j *Journal) Increment(key []byte, delta int64) (int64, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	current, found, err := j.current(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := dbtx.AddCounter(key, current, found, delta)
+	if err != nil {
+		return 0, err
+	}
+	err = j.update(func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, dbtx.EncodeCounter(n))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// update records the prior values, the lock must be held
+func (j *Journal) update(execute dbtx.Execute) error {
+	count := j.count
+	touched := make(map[string]struct{})
+
+	// records the prior value in the first write of the key after the checkpoint
+	record := func(bkt dbtx.Bucket, key []byte) error {
+		if IsJournalKey(key) {
+			return fmt.Errorf("journal: the key %q uses the reserved prefix", key)
+		}
+		if len(j.checkpoints) == 0 {
+			return nil
+		}
+		if _, ok := j.touched[string(key)]; ok {
+			return nil
+		}
+		if _, ok := touched[string(key)]; ok {
+			return nil
+		}
+
+		prior, err := j.KeyValueDB.Get(key)
+		exists := err == nil
+		if err != nil && !errors.Is(err, drivers.ErrNotFound) {
+			return err
+		}
+
+		cp := j.checkpoints[len(j.checkpoints)-1]
+		if err := bkt.Put(entryKey(cp, count), encodeEntry(key, prior, exists)); err != nil {
+			return err
+		}
+		count++
+		touched[string(key)] = struct{}{}
+		return bkt.Put(countKey(cp), encodeUint64(count))
+	}
+
+	err := j.KeyValueDB.Update(func(bkt dbtx.Bucket) error {
+		return execute(dbtx.BucketImp{
+			PutImp: func(key, value []byte) error {
+				if err := record(bkt, key); err != nil {
+					return err
+				}
+				return bkt.Put(key, value)
+			},
+			DeleteImp: func(key []byte) error {
+				if err := record(bkt, key); err != nil {
+					return err
+				}
+				return bkt.Delete(key)
+			},
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	j.count = count
+	for k := range touched {
+		j.touched[k] = struct{}{}
+	}
+	return nil
+}
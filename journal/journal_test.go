@@ -0,0 +1,255 @@
+package journal_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/drivertest"
+	"github.com/plateausnetwork/drivers/journal"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+var testBucket = []byte("tbucket")
+
+// withJournal runs the handler with bolt and badger
+func withJournal(t *testing.T, handler func(*testing.T, *journal.Journal)) {
+	for name, dbType := range map[string]drivers.DriverType{"bolt": drivers.Boltdb, "badger": drivers.Badgerdb} {
+		dbType := dbType
+		t.Run(name, func(t *testing.T) {
+			runners.WithTempDir(func(dir string) {
+				opts := drivers.DriverOptions()
+				opts.AddBucket(testBucket)
+				db, err := drivers.Open(dbType, dir+"/test.db", opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer db.Close()
+
+				j, err := journal.New(db)
+				if err != nil {
+					t.Fatal(err)
+				}
+				handler(t, j)
+			})
+		})
+	}
+}
+
+func expect(t *testing.T, j *journal.Journal, key, value string) {
+	t.Helper()
+	v, err := j.Get([]byte(key))
+	if value == "" {
+		if !errors.Is(err, drivers.ErrNotFound) {
+			t.Errorf("%s: expected not found, received %q %v", key, v, err)
+		}
+		return
+	}
+	if err != nil || !bytes.Equal(v, []byte(value)) {
+		t.Errorf("%s: expected %q, received %q %v", key, value, v, err)
+	}
+}
+
+func upsert(t *testing.T, j *journal.Journal, key, value string) {
+	t.Helper()
+	if err := j.Upsert([]byte(key), []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRollback(t *testing.T) {
+	withJournal(t, func(t *testing.T, j *journal.Journal) {
+		upsert(t, j, "a", "genesis")
+
+		for id, v := range []string{"b1", "b2", "b3"} {
+			if err := j.Checkpoint(uint64(id + 1)); err != nil {
+				t.Fatal(err)
+			}
+			upsert(t, j, "a", v)
+			upsert(t, j, v, v)
+		}
+		if err := j.Delete([]byte("b1")); err != nil {
+			t.Fatal(err)
+		}
+
+		// undo the blocks 2 and 3
+		if err := j.Rollback(2); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, j, "a", "b1")
+		expect(t, j, "b1", "b1")
+		expect(t, j, "b2", "")
+		expect(t, j, "b3", "")
+
+		if cps := j.Checkpoints(); len(cps) != 2 || cps[1] != 2 {
+			t.Errorf("invalid checkpoints after rollback: %v", cps)
+		}
+
+		// the checkpoint 2 receives the next writes
+		upsert(t, j, "a", "fork")
+		if err := j.Rollback(1); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, j, "a", "genesis")
+		expect(t, j, "b1", "")
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	withJournal(t, func(t *testing.T, j *journal.Journal) {
+		if err := j.Checkpoint(1); err != nil {
+			t.Fatal(err)
+		}
+
+		err := j.Update(func(bkt dbtx.Bucket) error {
+			if err := bkt.Put([]byte("a"), []byte("1")); err != nil {
+				return err
+			}
+			return bkt.Put([]byte("a"), []byte("2"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// the failed transaction records nothing
+		err = j.Update(func(bkt dbtx.Bucket) error {
+			bkt.Put([]byte("b"), []byte("1")) // nolint
+			return errors.New("test error")
+		})
+		if err == nil {
+			t.Error("the error of the execution must be returned")
+		}
+
+		if err := j.Rollback(1); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, j, "a", "")
+
+		if j.Length() != 0 {
+			t.Errorf("the keys of the journal must not be counted, length %d", j.Length())
+		}
+	})
+}
+
+func TestIteration(t *testing.T) {
+	withJournal(t, func(t *testing.T, j *journal.Journal) {
+		upsert(t, j, "a", "1")
+		if err := j.Checkpoint(1); err != nil {
+			t.Fatal(err)
+		}
+		upsert(t, j, "b", "2")
+
+		var keys, values []string
+		if err := j.KeyIterator(func(k []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.ForEach(func(v []byte) error {
+			values = append(values, string(v))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || keys[1] != "b" || len(values) != 2 || values[1] != "2" || j.Length() != 2 {
+			t.Errorf("the journal must be hidden: keys %q, values %q, length %d", keys, values, j.Length())
+		}
+
+		// Clean is journaled
+		j.Clean()
+		if j.Length() != 0 {
+			t.Errorf("expected an empty database, length %d", j.Length())
+		}
+		if err := j.Rollback(1); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, j, "a", "1")
+		expect(t, j, "b", "")
+	})
+}
+
+func TestReopen(t *testing.T) {
+	withJournal(t, func(t *testing.T, j *journal.Journal) {
+		upsert(t, j, "a", "0")
+		if err := j.Checkpoint(1); err != nil {
+			t.Fatal(err)
+		}
+		upsert(t, j, "a", "1")
+
+		// a new journal of the same database continues the checkpoint
+		reopened, err := journal.New(j.KeyValueDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		upsert(t, reopened, "a", "2")
+
+		if err := reopened.Rollback(1); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, reopened, "a", "0")
+	})
+}
+
+func TestPrune(t *testing.T) {
+	withJournal(t, func(t *testing.T, j *journal.Journal) {
+		for id := uint64(1); id <= 3; id++ {
+			if err := j.Checkpoint(id); err != nil {
+				t.Fatal(err)
+			}
+			upsert(t, j, "a", string(rune('0'+id)))
+		}
+
+		if err := j.Prune(3); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.Rollback(1); !errors.Is(err, journal.ErrUnknownCheckpoint) {
+			t.Errorf("expected %v, received %v", journal.ErrUnknownCheckpoint, err)
+		}
+
+		if err := j.Rollback(3); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, j, "a", "2")
+
+		if err := j.Checkpoint(2); err != journal.ErrInvalidCheckpoint {
+			t.Errorf("expected %v, received %v", journal.ErrInvalidCheckpoint, err)
+		}
+	})
+}
+
+// the conditional writes are recorded like the others
+func TestConditional(t *testing.T) {
+	withJournal(t, func(t *testing.T, j *journal.Journal) {
+		if err := j.Checkpoint(1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := j.Increment([]byte("nonce"), 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.PutIfAbsent([]byte("a"), []byte("v")); err != nil {
+			t.Fatal(err)
+		}
+		if err := j.PutIfAbsent([]byte("a"), []byte("x")); !errors.Is(err, drivers.ErrPrecondition) {
+			t.Errorf("expected ErrPrecondition, got %v", err)
+		}
+
+		if err := j.Rollback(1); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, j, "nonce", "")
+		expect(t, j, "a", "")
+	})
+
+	drivertest.RunConditional(t, func(dir string) (drivers.KeyValueDB, error) {
+		opts := drivers.DriverOptions()
+		opts.AddBucket(testBucket)
+		db, err := drivers.Open(drivers.Boltdb, dir+"/test.db", opts)
+		if err != nil {
+			return nil, err
+		}
+		return journal.New(db)
+	})
+}
@@ -0,0 +1,180 @@
+/*
+	Package maintenance compacts the databases in background, at each interval
+	or when their size reaches a threshold, and reports the bytes reclaimed.
+	The compactions of a target never overlap, the reads and writes of the
+	database continue, see the Compact of each driver.
+*/
+
+package maintenance
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/plateausnetwork/drivers"
+)
+
+// DefaultCheck is the interval of the size checks of the policies without Check
+var DefaultCheck = time.Minute
+
+var (
+	// ErrUnknownTarget is returned when the name wasn't added to the scheduler
+	ErrUnknownTarget = errors.New("maintenance: unknown target")
+	// ErrStopped is returned by the scheduler after Stop
+	ErrStopped = errors.New("maintenance: scheduler stopped")
+)
+
+// Target of the maintenance, like *bolt.Bolt and *badger.Badger
+type Target interface {
+	drivers.Compactor
+	Size() (int64, error)
+}
+
+// Policy of the compactions of a target
+// Interval: compacts at each interval, never if zero
+// Threshold: compacts when the size reaches it and has grown since the
+// last compaction, never if zero
+// Check: interval of the size checks, DefaultCheck if zero
+type Policy struct {
+	Interval  time.Duration
+	Threshold int64
+	Check     time.Duration
+}
+
+// Result of a compaction, Compaction is nil if it failed
+type Result struct {
+	Name       string
+	Compaction *drivers.Compaction
+	Err        error
+}
+
+type job struct {
+	name   string
+	target Target
+	policy Policy
+	last   int64 // size of the target after the last compaction
+	sync.Mutex
+}
+
+// Scheduler runs the compactions of the targets
+type Scheduler struct {
+	report  func(Result)
+	jobs    map[string]*job
+	done    chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+	sync.Mutex
+}
+
+// New returns the scheduler without targets
+// report receives the result of each compaction and failed size check, it may be nil
+func New(report func(Result)) *Scheduler {
+	if report == nil {
+		report = func(Result) {}
+	}
+	return &Scheduler{report: report, jobs: make(map[string]*job), done: make(chan struct{})}
+}
+
+// Add the target and starts its policy
+func (s *Scheduler) Add(name string, target Target, policy Policy) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.stopped {
+		return ErrStopped
+	}
+	if _, ok := s.jobs[name]; ok {
+		return fmt.Errorf("maintenance: the target %q already exists", name)
+	}
+	if policy.Check <= 0 {
+		policy.Check = DefaultCheck
+	}
+
+	j := &job{name: name, target: target, policy: policy}
+	s.jobs[name] = j
+	s.wg.Add(1)
+	go s.run(j)
+	return nil
+}
+
+// Compact the target now, after its running compaction
+func (s *Scheduler) Compact(name string) (*drivers.Compaction, error) {
+	s.Lock()
+	j, ok := s.jobs[name]
+	stopped := s.stopped
+	s.Unlock()
+	if stopped {
+		return nil, ErrStopped
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownTarget, name)
+	}
+	res := s.compact(j)
+	return res.Compaction, res.Err
+}
+
+// Stop the policies and waits the running compactions, the targets aren't closed
+func (s *Scheduler) Stop() {
+	s.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.done)
+	}
+	s.Unlock()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(j *job) {
+	defer s.wg.Done()
+
+	var interval, check <-chan time.Time
+	if j.policy.Interval > 0 {
+		ticker := time.NewTicker(j.policy.Interval)
+		defer ticker.Stop()
+		interval = ticker.C
+	}
+	if j.policy.Threshold > 0 {
+		ticker := time.NewTicker(j.policy.Check)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-interval:
+			s.compact(j)
+		case <-check:
+			size, err := j.target.Size()
+			if err != nil {
+				s.report(Result{Name: j.name, Err: err})
+				continue
+			}
+			j.Lock()
+			grown := size > j.last
+			j.Unlock()
+			if size >= j.policy.Threshold && grown {
+				s.compact(j)
+			}
+		}
+	}
+}
+
+// compact the target and reports the result
+func (s *Scheduler) compact(j *job) Result {
+	j.Lock()
+	res := Result{Name: j.name}
+	res.Compaction, res.Err = j.target.Compact()
+	if res.Err == nil {
+		// the size of the target, it may not be the size of the files
+		if size, err := j.target.Size(); err == nil {
+			j.last = size
+		}
+	}
+	j.Unlock()
+
+	s.report(res)
+	return res
+}
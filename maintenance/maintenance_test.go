@@ -0,0 +1,122 @@
+package maintenance_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/bolt"
+	"github.com/plateausnetwork/drivers/maintenance"
+	"github.com/plateausnetwork/drivers/runners"
+	"github.com/plateausnetwork/fs"
+)
+
+// target with a size set by the test, the compaction halves it
+type target struct {
+	size  int64
+	count int
+	sync.Mutex
+}
+
+func (t *target) Size() (int64, error) {
+	t.Lock()
+	defer t.Unlock()
+	return t.size, nil
+}
+
+func (t *target) Compact() (*drivers.Compaction, error) {
+	t.Lock()
+	defer t.Unlock()
+	t.count++
+	report := &drivers.Compaction{Before: t.size, After: t.size / 2}
+	t.size = report.After
+	return report, nil
+}
+
+func (t *target) grow(size int64) {
+	t.Lock()
+	defer t.Unlock()
+	t.size += size
+}
+
+func wait(t *testing.T, results <-chan maintenance.Result) maintenance.Result {
+	select {
+	case res := <-results:
+		return res
+	case <-time.After(5 * time.Second):
+		t.Fatal("the compaction didn't run")
+	}
+	return maintenance.Result{}
+}
+
+// the target is compacted when it reaches the threshold and only again after it grows
+func TestThreshold(t *testing.T) {
+	results := make(chan maintenance.Result, 10)
+	s := maintenance.New(func(res maintenance.Result) { results <- res })
+	defer s.Stop()
+
+	tgt := &target{size: 100}
+	err := s.Add("db", tgt, maintenance.Policy{Threshold: 100, Check: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := wait(t, results)
+	if res.Err != nil || res.Name != "db" || res.Compaction.Reclaimed() != 50 {
+		t.Errorf("wrong result %+v", res)
+	}
+
+	tgt.grow(60)
+	res = wait(t, results)
+	if res.Err != nil || res.Compaction.Before != 110 {
+		t.Errorf("wrong result %+v", res)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(results) != 0 {
+		t.Error("the target under the threshold was compacted")
+	}
+}
+
+func TestInterval(t *testing.T) {
+	results := make(chan maintenance.Result, 10)
+	s := maintenance.New(func(res maintenance.Result) { results <- res })
+
+	tgt := &target{size: 100}
+	if err := s.Add("db", tgt, maintenance.Policy{Interval: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	wait(t, results)
+	wait(t, results)
+	s.Stop()
+
+	if err := s.Add("other", tgt, maintenance.Policy{}); !errors.Is(err, maintenance.ErrStopped) {
+		t.Errorf("expected ErrStopped, got %v", err)
+	}
+}
+
+// the scheduler compacts a bolt database on demand
+func TestCompact(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db, err := bolt.Open(0, fs.Path(dir).Join("test.db").String(), []byte("rhz"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+
+		s := maintenance.New(nil)
+		defer s.Stop()
+		if err := s.Add("chain", db, maintenance.Policy{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Add("chain", db, maintenance.Policy{}); err == nil {
+			t.Error("the name must be unique")
+		}
+		if _, err := s.Compact("state"); !errors.Is(err, maintenance.ErrUnknownTarget) {
+			t.Errorf("expected ErrUnknownTarget, got %v", err)
+		}
+		if report, err := s.Compact("chain"); err != nil || report.Before == 0 {
+			t.Errorf("wrong report %+v %v", report, err)
+		}
+	})
+}
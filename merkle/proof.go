@@ -0,0 +1,155 @@
+package merkle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidProof is returned by Verify when the proof doesn't match the root
+var ErrInvalidProof = errors.New("merkle: invalid proof")
+
+// Proof of membership or non-membership of a key
+// Siblings: hashes of the siblings from the root to the end of the path
+// LeafPath and LeafValue: leaf found at the end of the path, empty if there is no leaf
+// in a non-membership proof, the leaf is of another key with the same path prefix
+type Proof struct {
+	Siblings  [][]byte
+	LeafPath  []byte
+	LeafValue []byte
+}
+
+// Bytes encodes the proof: siblings count | siblings | leaf path | leaf value
+func (p *Proof) Bytes() []byte {
+	buf := []byte{byte(len(p.Siblings) >> 8), byte(len(p.Siblings))}
+	for _, s := range p.Siblings {
+		buf = append(buf, s...)
+	}
+	if p.LeafPath != nil {
+		buf = append(append(buf, p.LeafPath...), p.LeafValue...)
+	}
+	return buf
+}
+
+// ParseProof decodes the proof encoded by Proof.Bytes
+func ParseProof(data []byte) (*Proof, error) {
+	if len(data) < 2 {
+		return nil, ErrInvalidProof
+	}
+	count := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if count > 8*HashSize || len(data) < count*HashSize {
+		return nil, ErrInvalidProof
+	}
+
+	p := &Proof{}
+	for i := 0; i < count; i++ {
+		p.Siblings = append(p.Siblings, data[:HashSize])
+		data = data[HashSize:]
+	}
+
+	switch len(data) {
+	case 0:
+	case 2 * HashSize:
+		p.LeafPath, p.LeafValue = data[:HashSize], data[HashSize:]
+	default:
+		return nil, ErrInvalidProof
+	}
+	return p, nil
+}
+
+// Prove returns the proof of the key in the current root
+func (t *Tree) Prove(key []byte) (*Proof, error) {
+	root, err := t.Root()
+	if err != nil {
+		return nil, err
+	}
+	return t.ProveAt(root, key)
+}
+
+// ProveAt returns the proof of the key in a current or past root
+func (t *Tree) ProveAt(root, key []byte) (*Proof, error) {
+	path := hash(key)
+	proof := &Proof{}
+
+	h := root
+	for depth := 0; !bytes.Equal(h, Empty); depth++ {
+		n, err := load(t.db.Get, h)
+		if err != nil {
+			return nil, err
+		}
+
+		if n.kind == leafNode {
+			proof.LeafPath, proof.LeafValue = n.left, n.right
+			break
+		}
+
+		if bit(path, depth) == 0 {
+			proof.Siblings = append(proof.Siblings, n.right)
+			h = n.left
+		} else {
+			proof.Siblings = append(proof.Siblings, n.left)
+			h = n.right
+		}
+	}
+	return proof, nil
+}
+
+// valid checks the lengths of the proof, like ParseProof, for the proofs built by hand
+func (p *Proof) valid() bool {
+	if len(p.Siblings) > 8*HashSize {
+		return false
+	}
+	for _, s := range p.Siblings {
+		if len(s) != HashSize {
+			return false
+		}
+	}
+	if p.LeafPath == nil && p.LeafValue == nil {
+		return true
+	}
+	return len(p.LeafPath) == HashSize && len(p.LeafValue) == HashSize
+}
+
+// Verify checks the proof of the key in the root
+// value nil verifies the non-membership of the key
+// it only needs the root, so it can be used without the database
+func Verify(root, key, value []byte, proof *Proof) error {
+	path := hash(key)
+	if proof == nil || !proof.valid() {
+		return ErrInvalidProof
+	}
+
+	h := Empty
+	switch {
+	case value != nil:
+		if !bytes.Equal(proof.LeafPath, path) || !bytes.Equal(proof.LeafValue, hash(value)) {
+			return fmt.Errorf("%w: the leaf isn't of the key/value", ErrInvalidProof)
+		}
+		h = leaf(path, proof.LeafValue).hash()
+	case proof.LeafPath != nil:
+		if bytes.Equal(proof.LeafPath, path) {
+			return fmt.Errorf("%w: the key exists", ErrInvalidProof)
+		}
+		// the leaf of another key must be in the same position of the path
+		for depth := range proof.Siblings {
+			if bit(proof.LeafPath, depth) != bit(path, depth) {
+				return fmt.Errorf("%w: the leaf isn't in the path of the key", ErrInvalidProof)
+			}
+		}
+		h = leaf(proof.LeafPath, proof.LeafValue).hash()
+	}
+
+	for depth := len(proof.Siblings) - 1; depth >= 0; depth-- {
+		if bit(path, depth) == 0 {
+			h = node{kind: internalNode, left: h, right: proof.Siblings[depth]}.hash()
+		} else {
+			h = node{kind: internalNode, left: proof.Siblings[depth], right: h}.hash()
+		}
+	}
+
+	if !bytes.Equal(h, root) {
+		return fmt.Errorf("%w: the root doesn't match", ErrInvalidProof)
+	}
+	return nil
+}
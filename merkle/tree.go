@@ -0,0 +1,288 @@
+/*
+	Package merkle implements a sparse Merkle tree over the key/values of a
+	drivers.KeyValueDB, so the contents of a bucket can be committed by a root hash.
+	The key/values are stored as they are and the nodes of the tree are stored
+	in the same database, with a reserved prefix, in the same transaction.
+	The path of each key is the sha256 of the key and a leaf is stored at the
+	first level where it's alone in its subtree.
+	The nodes are addressed by their hashes and never deleted, so the proofs
+	can be generated for any past root.
+*/
+
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Prefix of the keys of the tree, the key/values must not start with it
+var Prefix = []byte("\xffsmt\x00")
+
+// HashSize of the tree hashes
+const HashSize = sha256.Size
+
+// node types, used in the encoded nodes and in their hashes
+const (
+	leafNode     byte = 0
+	internalNode byte = 1
+)
+
+// Empty is the hash of an empty tree or subtree
+var Empty = make([]byte, HashSize)
+
+// rootKey stores the current root
+var rootKey = append(append([]byte{}, Prefix...), "root"...)
+
+func hash(data ...[]byte) []byte {
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d) // nolint
+	}
+	return h.Sum(nil)
+}
+
+// bit of the path at the depth, 0 is left and 1 is right
+func bit(path []byte, depth int) byte {
+	return (path[depth/8] >> (7 - uint(depth%8))) & 1
+}
+
+// node of the tree
+// leaf: path and value hash, internal: left and right hashes
+type node struct {
+	kind        byte
+	left, right []byte
+}
+
+func (n node) encode() []byte {
+	return append(append([]byte{n.kind}, n.left...), n.right...)
+}
+
+func (n node) hash() []byte {
+	return hash(n.encode())
+}
+
+func decodeNode(data []byte) (node, error) {
+	if len(data) != 1+2*HashSize || data[0] > internalNode {
+		return node{}, fmt.Errorf("merkle: corrupted node")
+	}
+	return node{kind: data[0], left: data[1 : 1+HashSize], right: data[1+HashSize:]}, nil
+}
+
+func leaf(path, valueHash []byte) node {
+	return node{kind: leafNode, left: path, right: valueHash}
+}
+
+func nodeKey(h []byte) []byte {
+	return append(append([]byte{}, Prefix...), h...)
+}
+
+// IsTreeKey returns true if the key is a node of the tree
+func IsTreeKey(key []byte) bool {
+	return bytes.HasPrefix(key, Prefix)
+}
+
+// Tree over the key/values of the database
+// all writes must be done by the Tree, so the root commits to all key/values
+type Tree struct {
+	db drivers.KeyValueDB
+	sync.Mutex
+}
+
+// New returns the tree of the database, the root is empty in a new database
+func New(db drivers.KeyValueDB) *Tree {
+	return &Tree{db: db}
+}
+
+// Root returns the current root hash
+func (t *Tree) Root() ([]byte, error) {
+	root, err := t.db.Get(rootKey)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return Empty, nil
+	}
+	return root, err
+}
+
+// Get the value of the key
+func (t *Tree) Get(key []byte) ([]byte, error) {
+	return t.db.Get(key)
+}
+
+// load the node by its hash
+func load(get func([]byte) ([]byte, error), h []byte) (node, error) {
+	data, err := get(nodeKey(h))
+	if err != nil {
+		return node{}, fmt.Errorf("merkle: node %x: %w", h, err)
+	}
+	return decodeNode(data)
+}
+
+// Update executes the writes in one transaction with the changes of the tree
+// it returns the new root
+func (t *Tree) Update(execute dbtx.Execute) ([]byte, error) {
+	t.Lock()
+	defer t.Unlock()
+
+	var root []byte
+	err := t.db.Update(func(bkt dbtx.Bucket) error {
+		current, err := t.Root()
+		if err != nil {
+			return err
+		}
+
+		tx := &txn{tree: t, bucket: bkt, root: current, nodes: make(map[string][]byte)}
+		err = execute(dbtx.BucketImp{
+			PutImp: func(key, value []byte) error {
+				if IsTreeKey(key) {
+					return fmt.Errorf("merkle: the key %q uses the reserved prefix", key)
+				}
+				if err := bkt.Put(key, value); err != nil {
+					return err
+				}
+				return tx.set(hash(key), hash(value))
+			},
+			DeleteImp: func(key []byte) error {
+				if IsTreeKey(key) {
+					return fmt.Errorf("merkle: the key %q uses the reserved prefix", key)
+				}
+				if err := bkt.Delete(key); err != nil {
+					return err
+				}
+				return tx.set(hash(key), nil)
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		root = tx.root
+		return bkt.Put(rootKey, root)
+	})
+	return root, err
+}
+
+// txn keeps the nodes created in one transaction
+type txn struct {
+	tree   *Tree
+	bucket dbtx.Bucket
+	root   []byte
+	nodes  map[string][]byte
+}
+
+func (tx *txn) get(key []byte) ([]byte, error) {
+	if data, ok := tx.nodes[string(key)]; ok {
+		return data, nil
+	}
+	return tx.tree.db.Get(key)
+}
+
+// store the node and returns its hash
+func (tx *txn) store(n node) ([]byte, error) {
+	h := n.hash()
+	key := nodeKey(h)
+	if _, ok := tx.nodes[string(key)]; !ok {
+		tx.nodes[string(key)] = n.encode()
+		if err := tx.bucket.Put(key, n.encode()); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// set the value hash of the path, nil deletes the path
+func (tx *txn) set(path, valueHash []byte) error {
+	root, err := tx.update(tx.root, 0, path, valueHash)
+	if err != nil {
+		return err
+	}
+	tx.root = root
+	return nil
+}
+
+// update the subtree at the depth and returns its new hash
+func (tx *txn) update(h []byte, depth int, path, valueHash []byte) ([]byte, error) {
+	if bytes.Equal(h, Empty) {
+		if valueHash == nil {
+			return Empty, nil
+		}
+		return tx.store(leaf(path, valueHash))
+	}
+
+	n, err := load(tx.get, h)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.kind == leafNode {
+		switch {
+		case bytes.Equal(n.left, path) && valueHash == nil:
+			return Empty, nil
+		case bytes.Equal(n.left, path):
+			return tx.store(leaf(path, valueHash))
+		case valueHash == nil:
+			return h, nil // the path doesn't exist
+		}
+		return tx.split(depth, n, leaf(path, valueHash))
+	}
+
+	left, right := n.left, n.right
+	if bit(path, depth) == 0 {
+		left, err = tx.update(left, depth+1, path, valueHash)
+	} else {
+		right, err = tx.update(right, depth+1, path, valueHash)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(left, Empty) && bytes.Equal(right, Empty):
+		return Empty, nil
+	case bytes.Equal(left, Empty) || bytes.Equal(right, Empty):
+		// a leaf alone in the subtree goes up
+		child := left
+		if bytes.Equal(left, Empty) {
+			child = right
+		}
+		if c, err := load(tx.get, child); err != nil {
+			return nil, err
+		} else if c.kind == leafNode {
+			return child, nil
+		}
+	}
+	return tx.store(node{kind: internalNode, left: left, right: right})
+}
+
+// split creates the subtree at the depth with the two leaves
+func (tx *txn) split(depth int, a, b node) ([]byte, error) {
+	bitA, bitB := bit(a.left, depth), bit(b.left, depth)
+	if bitA != bitB {
+		ha, err := tx.store(a)
+		if err != nil {
+			return nil, err
+		}
+		hb, err := tx.store(b)
+		if err != nil {
+			return nil, err
+		}
+		if bitA == 0 {
+			return tx.store(node{kind: internalNode, left: ha, right: hb})
+		}
+		return tx.store(node{kind: internalNode, left: hb, right: ha})
+	}
+
+	child, err := tx.split(depth+1, a, b)
+	if err != nil {
+		return nil, err
+	}
+	if bitA == 0 {
+		return tx.store(node{kind: internalNode, left: child, right: Empty})
+	}
+	return tx.store(node{kind: internalNode, left: Empty, right: child})
+}
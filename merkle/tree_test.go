@@ -0,0 +1,210 @@
+package merkle_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/merkle"
+	"github.com/plateausnetwork/drivers/runners"
+)
+
+var testBucket = []byte("tbucket")
+
+func withTree(handler func(*merkle.Tree)) {
+	runners.WithTempDir(func(dir string) {
+		opts := drivers.DriverOptions()
+		opts.AddBucket(testBucket)
+		db, err := drivers.Open(drivers.Boltdb, dir+"/test.db", opts)
+		if err != nil {
+			panic(err)
+		}
+		defer db.Close()
+
+		handler(merkle.New(db))
+	})
+}
+
+func put(tree *merkle.Tree, keys ...int) []byte {
+	root, err := tree.Update(func(bkt dbtx.Bucket) error {
+		for _, k := range keys {
+			if err := bkt.Put([]byte(fmt.Sprintf("k%d", k)), []byte(fmt.Sprintf("v%d", k))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
+
+func del(tree *merkle.Tree, keys ...int) []byte {
+	root, err := tree.Update(func(bkt dbtx.Bucket) error {
+		for _, k := range keys {
+			if err := bkt.Delete([]byte(fmt.Sprintf("k%d", k))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	return root
+}
+
+func TestRoot(t *testing.T) {
+	withTree(func(tree *merkle.Tree) {
+		root, err := tree.Root()
+		if err != nil || !bytes.Equal(root, merkle.Empty) {
+			t.Fatalf("the root of a new tree must be empty: %v", err)
+		}
+
+		r1 := put(tree, 1, 2, 3)
+		if current, _ := tree.Root(); !bytes.Equal(r1, current) {
+			t.Error("Update must return the current root")
+		}
+
+		// the root depends only on the key/values
+		withTree(func(other *merkle.Tree) {
+			put(other, 3)
+			put(other, 4)
+			put(other, 2, 1)
+			if r := del(other, 4); !bytes.Equal(r, r1) {
+				t.Error("the same key/values must have the same root")
+			}
+		})
+
+		if r := del(tree, 1, 2, 3); !bytes.Equal(r, merkle.Empty) {
+			t.Error("the root must be empty after delete all keys")
+		}
+
+		if v, err := tree.Get([]byte("k1")); !errors.Is(err, drivers.ErrNotFound) {
+			t.Errorf("the key must be deleted: %q %v", v, err)
+		}
+	})
+}
+
+func TestProofs(t *testing.T) {
+	withTree(func(tree *merkle.Tree) {
+		keys := make([]int, 50)
+		for i := range keys {
+			keys[i] = i
+		}
+		root := put(tree, keys...)
+
+		for _, k := range keys {
+			key := []byte(fmt.Sprintf("k%d", k))
+			proof, err := tree.Prove(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// encoded and decoded as a standalone verifier would do
+			proof, err = merkle.ParseProof(proof.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := merkle.Verify(root, key, []byte(fmt.Sprintf("v%d", k)), proof); err != nil {
+				t.Errorf("membership of %s: %v", key, err)
+			}
+			if err := merkle.Verify(root, key, []byte("wrong"), proof); err == nil {
+				t.Errorf("wrong value of %s must be invalid", key)
+			}
+			if err := merkle.Verify(root, key, nil, proof); err == nil {
+				t.Errorf("non-membership of %s must be invalid", key)
+			}
+		}
+
+		for i := 100; i < 150; i++ {
+			key := []byte(fmt.Sprintf("k%d", i))
+			proof, err := tree.Prove(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := merkle.Verify(root, key, nil, proof); err != nil {
+				t.Errorf("non-membership of %s: %v", key, err)
+			}
+			if err := merkle.Verify(root, key, []byte("v"), proof); !errors.Is(err, merkle.ErrInvalidProof) {
+				t.Errorf("membership of %s must be invalid", key)
+			}
+		}
+	})
+}
+
+func TestInvalidProof(t *testing.T) {
+	withTree(func(tree *merkle.Tree) {
+		root := put(tree, 1, 2, 3)
+		key := []byte("k100")
+		proof, err := tree.Prove(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.LeafPath == nil {
+			t.Fatal("expected the leaf of another key")
+		}
+
+		truncated := *proof
+		truncated.LeafPath = truncated.LeafPath[:0]
+		if err := merkle.Verify(root, key, nil, &truncated); !errors.Is(err, merkle.ErrInvalidProof) {
+			t.Errorf("a truncated leaf path must be invalid: %v", err)
+		}
+		if err := merkle.Verify(root, key, nil, nil); !errors.Is(err, merkle.ErrInvalidProof) {
+			t.Errorf("a nil proof must be invalid: %v", err)
+		}
+	})
+}
+
+func TestPastRoot(t *testing.T) {
+	withTree(func(tree *merkle.Tree) {
+		old := put(tree, 1)
+		put(tree, 2)
+		del(tree, 1)
+
+		proof, err := tree.ProveAt(old, []byte("k1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := merkle.Verify(old, []byte("k1"), []byte("v1"), proof); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRollback(t *testing.T) {
+	withTree(func(tree *merkle.Tree) {
+		root := put(tree, 1)
+
+		_, err := tree.Update(func(bkt dbtx.Bucket) error {
+			bkt.Put([]byte("k2"), []byte("v2")) // nolint
+			return fmt.Errorf("test error")
+		})
+		if err == nil {
+			t.Error("the error of the execution must be returned")
+		}
+
+		if current, _ := tree.Root(); !bytes.Equal(current, root) {
+			t.Error("the root must not change after a rollback")
+		}
+
+		if _, err := tree.Update(func(bkt dbtx.Bucket) error {
+			return bkt.Put(append(append([]byte{}, merkle.Prefix...), 'x'), nil)
+		}); err == nil {
+			t.Error("the reserved prefix must not be used")
+		}
+		if _, err := tree.Update(func(bkt dbtx.Bucket) error {
+			return bkt.Delete(append(append([]byte{}, merkle.Prefix...), 'x'))
+		}); err == nil {
+			t.Error("the nodes of the tree must not be deleted")
+		}
+		if current, _ := tree.Root(); !bytes.Equal(current, root) {
+			t.Error("the root must not change after a rejected delete")
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package drivers
+
+import (
+	"bytes"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// DeletePrefix deletes the keys with the prefix and returns how many were removed
+// the drivers without RangeDeleter iterate the keys and delete them inside one transaction
+func DeletePrefix(db KeyValueDB, prefix []byte) (int, error) {
+	if deleter, ok := db.(RangeDeleter); ok {
+		return deleter.DeletePrefix(prefix)
+	}
+	return DeleteRange(db, prefix, dbtx.PrefixEnd(prefix))
+}
+
+// DeleteRange deletes the keys from start until end (exclusive) and returns how many were removed
+// end nil deletes until the last key
+func DeleteRange(db KeyValueDB, start, end []byte) (int, error) {
+	if deleter, ok := db.(RangeDeleter); ok {
+		return deleter.DeleteRange(start, end)
+	}
+
+	var keys [][]byte
+	err := db.KeyIterator(func(key []byte) error {
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			return errStop
+		}
+		if bytes.Compare(key, start) >= 0 {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return 0, err
+	}
+
+	err = db.Update(func(bkt dbtx.Bucket) error {
+		for _, key := range keys {
+			if err := bkt.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+// CountPrefix returns the amount of keys with the prefix
+func CountPrefix(db KeyValueDB, prefix []byte) (int, error) {
+	if deleter, ok := db.(RangeDeleter); ok {
+		return deleter.CountPrefix(prefix)
+	}
+
+	count := 0
+	err := db.KeyIterator(func(key []byte) error {
+		if bytes.HasPrefix(key, prefix) {
+			count++
+		} else if bytes.Compare(key, prefix) > 0 {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,146 @@
+package replication
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/watch"
+)
+
+// Follower applies the records of a log to the database
+// the database must only be written by the follower
+// the records are applied one at a time, Apply can be called concurrently
+type Follower struct {
+	db  drivers.KeyValueDB
+	seq uint64
+	sync.Mutex
+}
+
+// NewFollower returns the follower of the database, from the last record applied
+func NewFollower(db drivers.KeyValueDB) (*Follower, error) {
+	f := &Follower{db: db}
+	buf, err := db.Get(seqKey)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != 8 {
+		return nil, fmt.Errorf("%w: invalid sequence", ErrCorrupted)
+	}
+	f.seq = binary.BigEndian.Uint64(buf)
+	return f, nil
+}
+
+// DB returns the database without the keys of the replication, like the
+// sequence of the follower, the reads of the replica must use it
+func (f *Follower) DB() drivers.KeyValueDB {
+	return view{f.db}
+}
+
+// Sequence returns the sequence of the last record applied
+// the leader can stream the records after it
+func (f *Follower) Sequence() uint64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.seq
+}
+
+// Apply the record and its sequence in one transaction
+// the records already applied are ignored, ErrGap is returned if a record is missing
+func (f *Follower) Apply(rec *Record) error {
+	f.Lock()
+	defer f.Unlock()
+	if rec.Seq <= f.seq {
+		return nil
+	}
+	if rec.Seq != f.seq+1 {
+		return fmt.Errorf("%w: expected %d, got %d", ErrGap, f.seq+1, rec.Seq)
+	}
+
+	var seq [8]byte
+	binary.BigEndian.PutUint64(seq[:], rec.Seq)
+	err := f.db.Update(func(bkt dbtx.Bucket) error {
+		for _, e := range rec.Events {
+			var err error
+			if e.Op == watch.Delete {
+				err = bkt.Delete(e.Key)
+			} else {
+				err = bkt.Put(e.Key, e.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return bkt.Put(seqKey, seq[:])
+	})
+	if err != nil {
+		return err
+	}
+	f.seq = rec.Seq
+	return nil
+}
+
+// Replicate applies the records of the stream until its end
+func (f *Follower) Replicate(r io.Reader) error {
+	reader := NewReader(r)
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := f.Apply(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Tail applies the records of the log file and waits for the new ones,
+// checking the file at each interval, until the context is done
+func (f *Follower) Tail(ctx context.Context, path string, interval time.Duration) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var offset int64
+	for {
+		// the incomplete record is read again in the next check
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		reader := NewReader(file)
+		for {
+			rec, err := reader.Next()
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := f.Apply(rec); err != nil {
+				return err
+			}
+		}
+		offset += reader.Offset()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,363 @@
+/*
+	Package replication keeps a read replica of a database in sync with it.
+	The Log writes each committed transaction as a sequence-numbered record in
+	a log file, the Follower reads the records from the file or from any
+	io.Reader and applies them to another database.
+	The last record is also stored in the database, in the same transaction of
+	the writes, so the log file is repaired when it misses the last commit.
+	The follower stores the sequence of the last record applied in its database,
+	in the same transaction of the record, so it resumes after the restarts.
+	The keys of the replication are hidden from the iterations and Length of
+	the Log and of the database returned by Follower.DB.
+*/
+
+package replication
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/watch"
+)
+
+// Prefix of the replication keys, the key/values must not start with it
+var Prefix = []byte("\xffrep\x00")
+
+// keys of the replication
+// last: last record written by the log
+// seq: sequence of the last record applied by the follower
+var (
+	lastKey = append(append([]byte{}, Prefix...), "last"...)
+	seqKey  = append(append([]byte{}, Prefix...), "seq"...)
+)
+
+// IsReplicationKey returns true if the key is used by the replication
+func IsReplicationKey(key []byte) bool {
+	return bytes.HasPrefix(key, Prefix)
+}
+
+// pageSize of the scans of ForEach
+var pageSize = 256
+
+// view of the database without the keys of the replication
+type view struct {
+	drivers.KeyValueDB
+}
+
+// KeyIterator iterates the keys of the database, without the keys of the replication
+func (v view) KeyIterator(query func([]byte) error) error {
+	return v.KeyValueDB.KeyIterator(func(key []byte) error {
+		if IsReplicationKey(key) {
+			return nil
+		}
+		return query(key)
+	})
+}
+
+// ForEach value of the database, without the values of the replication
+func (v view) ForEach(query func([]byte) error) error {
+	opts := drivers.ScanOptions{Limit: pageSize}
+	for {
+		page, err := drivers.Scan(v.KeyValueDB, opts)
+		if err != nil {
+			return err
+		}
+		for _, kv := range page.Items {
+			if IsReplicationKey(kv.Key) {
+				continue
+			}
+			if err := query(kv.Value); err != nil {
+				return err
+			}
+		}
+		if page.Token == "" {
+			return nil
+		}
+		opts.Token = page.Token
+	}
+}
+
+// Length amount of keys of the database, without the keys of the replication
+func (v view) Length() int {
+	n, err := drivers.CountPrefix(v.KeyValueDB, Prefix)
+	if err != nil {
+		return v.KeyValueDB.Length()
+	}
+	return v.KeyValueDB.Length() - n
+}
+
+// Log of the changes of the database
+// all writes must be done by the Log, so they are recorded
+type Log struct {
+	view
+	file *os.File
+	seq  uint64
+	err  error // the log stops after a failed write in the file
+	sync.Mutex
+}
+
+// Open the log file of the database, it's created if it doesn't exist
+// the incomplete record at the end of the file is removed and the last
+// commit of the database is written if it's missing
+func Open(db drivers.KeyValueDB, path string) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{view: view{db}, file: file}
+	if err := l.recover(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// recover the file from the last valid record and the last record of the database
+func (l *Log) recover() error {
+	r := NewReader(l.file)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("on reading the log at %d: %w", r.Offset(), err)
+		}
+		l.seq = rec.Seq
+	}
+	if err := l.file.Truncate(r.Offset()); err != nil {
+		return err
+	}
+	if _, err := l.file.Seek(r.Offset(), io.SeekStart); err != nil {
+		return err
+	}
+
+	buf, err := l.KeyValueDB.Get(lastKey)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	last, err := decodeRecord(buf)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case last.Seq == l.seq+1:
+		return l.append(last)
+	case last.Seq != l.seq:
+		return fmt.Errorf("%w: log at %d, database at %d", ErrOutOfSync, l.seq, last.Seq)
+	}
+	return nil
+}
+
+func (l *Log) append(rec *Record) error {
+	if _, err := l.file.Write(rec.encode()); err != nil {
+		return err
+	}
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.seq = rec.Seq
+	return nil
+}
+
+// Sequence returns the sequence of the last record
+func (l *Log) Sequence() uint64 {
+	l.Lock()
+	defer l.Unlock()
+	return l.seq
+}
+
+// Upsert records and updates the key/value
+func (l *Log) Upsert(key, value []byte) error {
+	return l.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, value)
+	})
+}
+
+// Delete records and deletes the key/value
+func (l *Log) Delete(key []byte) error {
+	return l.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Delete(key)
+	})
+}
+
+// Update records the writes as one record, after the commit
+// the transactions without writes have no record
+func (l *Log) Update(execute dbtx.Execute) error {
+	l.Lock()
+	defer l.Unlock()
+	return l.update(execute)
+}
+
+// Clean deletes all key/values in one record, so the followers are cleaned too
+func (l *Log) Clean() {
+	l.Lock()
+	defer l.Unlock()
+
+	var keys [][]byte
+	if err := l.KeyIterator(func(key []byte) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return
+	}
+	l.update(func(bkt dbtx.Bucket) error { //nolint:errcheck
+		for _, key := range keys {
+			if err := bkt.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// current returns the value of the key and false if it doesn't exist
+func (l *Log) current(key []byte) ([]byte, bool, error) {
+	value, err := l.KeyValueDB.Get(key)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return nil, false, nil
+	}
+	return value, err == nil, err
+}
+
+// CompareAndSwap records and writes the value if the current one is the expected
+// the writes of the log are serialized, so the check and the write are atomic
+func (l *Log) CompareAndSwap(key, expected, value []byte) error {
+	l.Lock()
+	defer l.Unlock()
+
+	current, found, err := l.current(key)
+	if err != nil {
+		return err
+	}
+	if err := dbtx.CheckSwap(key, current, found, expected); err != nil {
+		return err
+	}
+	return l.update(func(bkt dbtx.Bucket) error {
+		if value == nil {
+			return bkt.Delete(key)
+		}
+		return bkt.Put(key, value)
+	})
+}
+
+// PutIfAbsent records and writes the key/value if the key doesn't exist
+func (l *Log) PutIfAbsent(key, value []byte) error {
+	return l.CompareAndSwap(key, nil, value)
+}
+
+// Increment records and adds delta to the counter of the key
+func (l *Log) Increment(key []byte, delta int64) (int64, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	current, found, err := l.current(key)
+	if err != nil {
+		return 0, err
+	}
+	n, err := dbtx.AddCounter(key, current, found, delta)
+	if err != nil {
+		return 0, err
+	}
+	err = l.update(func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, dbtx.EncodeCounter(n))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// update records the writes, the lock must be held
+func (l *Log) update(execute dbtx.Execute) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	rec := &Record{Seq: l.seq + 1}
+	err := l.KeyValueDB.Update(func(bkt dbtx.Bucket) error {
+		var events watch.Recorder
+		err := execute(dbtx.BucketImp{
+			PutImp: func(key, value []byte) error {
+				if IsReplicationKey(key) {
+					return fmt.Errorf("replication: the key %q uses the reserved prefix", key)
+				}
+				events.Put(key, value)
+				return bkt.Put(key, value)
+			},
+			DeleteImp: func(key []byte) error {
+				if IsReplicationKey(key) {
+					return fmt.Errorf("replication: the key %q uses the reserved prefix", key)
+				}
+				events.Delete(key)
+				return bkt.Delete(key)
+			},
+		})
+		if err != nil || len(events.Events) == 0 {
+			return err
+		}
+		rec.Events = events.Events
+		return bkt.Put(lastKey, rec.encode())
+	})
+	if err != nil || len(rec.Events) == 0 {
+		return err
+	}
+
+	// the commit is only in the database, the next Open writes it
+	if err := l.append(rec); err != nil {
+		l.err = fmt.Errorf("replication: the log must be reopened: %w", err)
+		return l.err
+	}
+	return nil
+}
+
+// Stream writes the records after the sequence to w
+// a follower uses it to catch up from its sequence
+func (l *Log) Stream(w io.Writer, after uint64) error {
+	l.Lock()
+	file, err := os.Open(l.file.Name())
+	l.Unlock()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := NewReader(file)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.Seq <= after {
+			continue
+		}
+		if _, err := w.Write(rec.encode()); err != nil {
+			return err
+		}
+	}
+}
+
+// Close the log file and the database, it returns the first error
+func (l *Log) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	err := l.file.Close()
+	if dberr := l.KeyValueDB.Close(); err == nil {
+		err = dberr
+	}
+	return err
+}
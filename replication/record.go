@@ -0,0 +1,145 @@
+package replication
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/plateausnetwork/drivers/watch"
+)
+
+var (
+	// ErrCorrupted is returned when a record of the log doesn't match its checksum
+	ErrCorrupted = errors.New("replication: corrupted record")
+	// ErrOutOfSync is returned when the log and the database have different commits
+	ErrOutOfSync = errors.New("replication: the log and the database are out of sync")
+	// ErrGap is returned by the follower when a record is missing in the log
+	ErrGap = errors.New("replication: missing record in the log")
+)
+
+// headerSize of the records: sequence | payload length | checksum of the payload
+const headerSize = 16
+
+// Record of one committed transaction
+type Record struct {
+	Seq    uint64
+	Events []watch.Event
+}
+
+// encode the record: header | payload
+// each event of the payload: op | key length | key | value length | value
+// the value is omitted in deletes
+func (rec *Record) encode() []byte {
+	var payload []byte
+	var size [binary.MaxVarintLen64]byte
+	for _, e := range rec.Events {
+		payload = append(payload, byte(e.Op))
+		n := binary.PutUvarint(size[:], uint64(len(e.Key)))
+		payload = append(append(payload, size[:n]...), e.Key...)
+		if e.Op == watch.Put {
+			n = binary.PutUvarint(size[:], uint64(len(e.Value)))
+			payload = append(append(payload, size[:n]...), e.Value...)
+		}
+	}
+
+	buf := make([]byte, headerSize, headerSize+len(payload))
+	binary.BigEndian.PutUint64(buf, rec.Seq)
+	binary.BigEndian.PutUint32(buf[8:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[12:], crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+// decodePayload returns the events of the payload
+func decodePayload(payload []byte) ([]watch.Event, error) {
+	var events []watch.Event
+	field := func() ([]byte, error) {
+		size, n := binary.Uvarint(payload)
+		if n <= 0 || uint64(len(payload)-n) < size {
+			return nil, ErrCorrupted
+		}
+		f := payload[n : n+int(size)]
+		payload = payload[n+int(size):]
+		return f, nil
+	}
+
+	for len(payload) > 0 {
+		e := watch.Event{Op: watch.Op(payload[0])}
+		payload = payload[1:]
+
+		var err error
+		if e.Key, err = field(); err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case watch.Put:
+			if e.Value, err = field(); err != nil {
+				return nil, err
+			}
+		case watch.Delete:
+		default:
+			return nil, fmt.Errorf("%w: unknown operation %d", ErrCorrupted, e.Op)
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// decodeRecord of the buffer with only one record
+func decodeRecord(buf []byte) (*Record, error) {
+	if len(buf) < headerSize || len(buf)-headerSize != int(binary.BigEndian.Uint32(buf[8:])) {
+		return nil, ErrCorrupted
+	}
+	payload := buf[headerSize:]
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(buf[12:]) {
+		return nil, ErrCorrupted
+	}
+	events, err := decodePayload(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{Seq: binary.BigEndian.Uint64(buf), Events: events}, nil
+}
+
+// Reader of the records of a log file or stream
+type Reader struct {
+	r      io.Reader
+	offset int64
+}
+
+// NewReader returns the reader of the records
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Offset returns the amount of bytes of the records already read
+func (r *Reader) Offset() int64 {
+	return r.offset
+}
+
+// Next returns the next record
+// io.EOF is returned at the end of the records and io.ErrUnexpectedEOF if
+// the last record is incomplete, like in a log being written
+func (r *Reader) Next() (*Record, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, headerSize+int(binary.BigEndian.Uint32(header[8:])))
+	copy(buf, header)
+	if _, err := io.ReadFull(r.r, buf[headerSize:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	rec, err := decodeRecord(buf)
+	if err != nil {
+		return nil, err
+	}
+	r.offset += int64(len(buf))
+	return rec, nil
+}
@@ -0,0 +1,349 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/drivertest"
+	"github.com/plateausnetwork/drivers/replication"
+	"github.com/plateausnetwork/drivers/runners"
+	"github.com/plateausnetwork/drivers/watch"
+)
+
+var testBucket = []byte("tbucket")
+
+func open(t *testing.T, dbType drivers.DriverType, path string) drivers.KeyValueDB {
+	t.Helper()
+	opts := drivers.DriverOptions()
+	opts.AddBucket(testBucket)
+	db, err := drivers.Open(dbType, path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func openLog(t *testing.T, dir string) *replication.Log {
+	t.Helper()
+	l, err := replication.Open(open(t, drivers.Boltdb, dir+"/leader.db"), dir+"/leader.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func openFollower(t *testing.T, db drivers.KeyValueDB) *replication.Follower {
+	t.Helper()
+	f, err := replication.NewFollower(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func write(t *testing.T, l *replication.Log, keys ...string) {
+	t.Helper()
+	for _, key := range keys {
+		if err := l.Upsert([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expect(t *testing.T, db drivers.KeyValueDB, key, value string) {
+	t.Helper()
+	v, err := db.Get([]byte(key))
+	if value == "" {
+		if !errors.Is(err, drivers.ErrNotFound) {
+			t.Errorf("%s: expected not found, received %q %v", key, v, err)
+		}
+		return
+	}
+	if err != nil || !bytes.Equal(v, []byte(value)) {
+		t.Errorf("%s: expected %q, received %q %v", key, value, v, err)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		l := openLog(t, dir)
+		defer l.Close()
+
+		write(t, l, "a", "b")
+		err := l.Update(func(bkt dbtx.Bucket) error {
+			if err := bkt.Delete([]byte("a")); err != nil {
+				return err
+			}
+			return bkt.Put([]byte("c"), []byte("vc"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if l.Sequence() != 3 {
+			t.Errorf("expected the sequence 3, got %d", l.Sequence())
+		}
+
+		file, err := os.Open(dir + "/leader.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		db := open(t, drivers.Badgerdb, dir+"/follower")
+		if err := openFollower(t, db).Replicate(file); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, db, "a", "")
+		expect(t, db, "b", "vb")
+		expect(t, db, "c", "vc")
+		db.Close()
+
+		// resumes from the sequence after the restart
+		write(t, l, "d")
+		db = open(t, drivers.Badgerdb, dir+"/follower")
+		defer db.Close()
+		f := openFollower(t, db)
+		if f.Sequence() != 3 {
+			t.Errorf("expected to resume from 3, got %d", f.Sequence())
+		}
+
+		var stream bytes.Buffer
+		if err := l.Stream(&stream, f.Sequence()); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Replicate(&stream); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, db, "d", "vd")
+		if f.Sequence() != 4 {
+			t.Errorf("expected the sequence 4, got %d", f.Sequence())
+		}
+
+		// the keys of the replication are hidden
+		if l.Length() != 3 || f.DB().Length() != 3 {
+			t.Errorf("expected 3 keys, got %d in the log and %d in the replica", l.Length(), f.DB().Length())
+		}
+		var keys []string
+		if err := f.DB().KeyIterator(func(k []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		if err := l.ForEach(func([]byte) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 3 || keys[0] != "b" || count != 3 {
+			t.Errorf("invalid keys %q and %d values", keys, count)
+		}
+	})
+}
+
+func TestClean(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		l := openLog(t, dir)
+		defer l.Close()
+		write(t, l, "a", "b")
+		l.Clean()
+		if l.Length() != 0 || l.Sequence() != 3 {
+			t.Errorf("the clean must be recorded, length %d and sequence %d", l.Length(), l.Sequence())
+		}
+
+		var stream bytes.Buffer
+		if err := l.Stream(&stream, 0); err != nil {
+			t.Fatal(err)
+		}
+		db := open(t, drivers.Boltdb, dir+"/follower.db")
+		defer db.Close()
+		f := openFollower(t, db)
+		if err := f.Replicate(&stream); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, db, "a", "")
+		if f.DB().Length() != 0 {
+			t.Errorf("the replica must be empty, length %d", f.DB().Length())
+		}
+	})
+}
+
+// the last commit is in the database but not in the log file
+func TestRecover(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		l := openLog(t, dir)
+		write(t, l, "a", "b")
+		l.Close()
+
+		info, err := os.Stat(dir + "/leader.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Truncate(dir+"/leader.log", info.Size()-3); err != nil {
+			t.Fatal(err)
+		}
+
+		l = openLog(t, dir)
+		defer l.Close()
+		if l.Sequence() != 2 {
+			t.Errorf("expected the sequence 2, got %d", l.Sequence())
+		}
+
+		file, err := os.Open(dir + "/leader.log")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		r := replication.NewReader(file)
+		for seq := uint64(1); seq <= 2; seq++ {
+			rec, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Seq != seq {
+				t.Errorf("expected the record %d, got %d", seq, rec.Seq)
+			}
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Errorf("expected the end of the log, got %v", err)
+		}
+	})
+}
+
+func TestGap(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db := open(t, drivers.Boltdb, dir+"/follower.db")
+		defer db.Close()
+
+		rec := &replication.Record{Seq: 2, Events: []watch.Event{{Op: watch.Put, Key: []byte("a")}}}
+		if err := openFollower(t, db).Apply(rec); !errors.Is(err, replication.ErrGap) {
+			t.Errorf("expected ErrGap, got %v", err)
+		}
+	})
+}
+
+func TestConcurrentApply(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		db := open(t, drivers.Boltdb, dir+"/follower.db")
+		defer db.Close()
+		f := openFollower(t, db)
+
+		errs := make(chan error, 4)
+		for i := 0; i < 4; i++ {
+			go func() {
+				for seq := uint64(1); seq <= 50; seq++ {
+					key := []byte(fmt.Sprintf("k%d", seq))
+					rec := &replication.Record{Seq: seq, Events: []watch.Event{{Op: watch.Put, Key: key, Value: key}}}
+					if err := f.Apply(rec); err != nil {
+						errs <- err
+						return
+					}
+				}
+				errs <- nil
+			}()
+		}
+		for i := 0; i < 4; i++ {
+			if err := <-errs; err != nil {
+				t.Fatal(err)
+			}
+		}
+		if f.Sequence() != 50 || f.DB().Length() != 50 {
+			t.Errorf("expected 50 records applied, got %d and %d keys", f.Sequence(), f.DB().Length())
+		}
+	})
+}
+
+func TestReservedPrefix(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		l := openLog(t, dir)
+		defer l.Close()
+
+		key := append(append([]byte{}, replication.Prefix...), "x"...)
+		if err := l.Upsert(key, []byte("v")); err == nil {
+			t.Error("the reserved prefix must be rejected")
+		}
+	})
+}
+
+func TestTail(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		l := openLog(t, dir)
+		defer l.Close()
+		write(t, l, "a")
+
+		db := open(t, drivers.Ristretto, "follower")
+		defer db.Close()
+		f := openFollower(t, db)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+		go func() {
+			done <- f.Tail(ctx, dir+"/leader.log", time.Millisecond)
+		}()
+
+		write(t, l, "b")
+		deadline := time.Now().Add(5 * time.Second)
+		for _, err := db.Get([]byte("b")); err != nil; _, err = db.Get([]byte("b")) {
+			if time.Now().After(deadline) {
+				t.Fatal("timeout waiting for the replication")
+			}
+			time.Sleep(time.Millisecond)
+		}
+
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("expected the cancel of the context, got %v", err)
+		}
+		expect(t, db, "a", "va")
+	})
+}
+
+// the conditional writes are replicated like the others
+func TestConditional(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		l := openLog(t, dir)
+		defer l.Close()
+
+		if _, err := l.Increment([]byte("nonce"), 2); err != nil {
+			t.Fatal(err)
+		}
+		if err := l.CompareAndSwap([]byte("nonce"), []byte("x"), nil); !errors.Is(err, drivers.ErrPrecondition) {
+			t.Errorf("expected ErrPrecondition, got %v", err)
+		}
+		if l.Sequence() != 1 {
+			t.Errorf("only the increment must be recorded, got %d records", l.Sequence())
+		}
+
+		var stream bytes.Buffer
+		if err := l.Stream(&stream, 0); err != nil {
+			t.Fatal(err)
+		}
+		db := open(t, drivers.Boltdb, dir+"/follower.db")
+		defer db.Close()
+		if err := openFollower(t, db).Replicate(&stream); err != nil {
+			t.Fatal(err)
+		}
+		expect(t, db, "nonce", string(dbtx.EncodeCounter(2)))
+	})
+
+	drivertest.RunConditional(t, func(dir string) (drivers.KeyValueDB, error) {
+		opts := drivers.DriverOptions()
+		opts.AddBucket(testBucket)
+		db, err := drivers.Open(drivers.Boltdb, dir+"/leader.db", opts)
+		if err != nil {
+			return nil, err
+		}
+		return replication.Open(db, dir+"/leader.log")
+	})
+}
@@ -6,52 +6,205 @@
 package ristretto
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	r "github.com/dgraph-io/ristretto"
 	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/watch"
 )
 
+var (
+	// ErrRejected is returned when the ristretto policy declines the key/value
+	ErrRejected = errors.New("ristretto: key/value rejected by the cache policy")
+	// ErrDropped is returned when the ristretto buffers are full and the write is dropped
+	ErrDropped = errors.New("ristretto: key/value dropped by the cache buffers")
+	// ErrTimeout is returned when the write isn't applied inside of the Cache.Timeout
+	ErrTimeout = errors.New("ristretto: timeout waiting for the write to be applied")
+)
+
+// RollbackError is returned by Update when a write fails and the previous
+// values can't be restored, it matches the error of the write
+// Err: error of the write, Rollback: first error of the restore
+type RollbackError struct {
+	Err      error
+	Rollback error
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("%v, on rolling back: %v", e.Err, e.Rollback)
+}
+
+// Unwrap returns the error of the write
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// DefaultTimeout to wait for a write to be applied in the cache
+const DefaultTimeout = time.Second
+
 // Cache memory-bound
 // keys is the current list
 // this list helps in ForEach() with business rules
 // because the ristretto has only get by one key
+// the list follows the evictions of ristretto, so it has only resident keys
+// Timeout: max time that a write waits to be applied by ristretto
+// the writes are serialized by txn, so the readers never see a transaction half applied
 type Cache struct {
-	name   string
-	tp     int
-	opened bool
-	db     *r.Cache
-	keys   map[string]int
+	name    string
+	tp      int
+	opened  bool
+	db      *r.Cache
+	keys    map[string]*entry
+	onEvict EvictFunc
+	hub     *watch.Hub
+	seqs    map[string]*sequence // in memory, out of the cache
+	waits   chan chan struct{}   // requests of the waiter
+	txn     sync.RWMutex
+	Timeout time.Duration
 	sync.RWMutex
 }
 
+// EvictFunc receives the key/value evicted from the cache by ristretto
+type EvictFunc func(key, value []byte)
+
+// operation staged by a transaction
+// value is ignored when the operation is a delete
+type operation struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// entry is the value stored in ristretto
+// ristretto only knows the hash of the keys, so the key is kept with the value
+type entry struct {
+	key      string
+	value    []byte
+	rejected bool
+}
+
+// DefaultMaxCost of the cache, the cost of a key/value is the size of its value
+const DefaultMaxCost = 1000000
+
+// Options of OpenWith
+// MaxCost: max size of the values in the cache, DefaultMaxCost if zero
+type Options struct {
+	MaxCost int64
+}
+
 // Open returns the cache in memory
 func Open(tp int, name string) (*Cache, error) {
+	return OpenWith(tp, name, Options{})
+}
+
+// OpenWith returns the cache in memory with the options
+func OpenWith(tp int, name string, opts Options) (*Cache, error) {
+	if opts.MaxCost == 0 {
+		opts.MaxCost = DefaultMaxCost
+	}
+	cache := &Cache{
+		name:    name,
+		tp:      tp,
+		opened:  true,
+		keys:    make(map[string]*entry),
+		hub:     watch.NewHub(),
+		seqs:    make(map[string]*sequence),
+		waits:   make(chan chan struct{}),
+		Timeout: DefaultTimeout,
+	}
+
 	// default parameters
 	cacheDB, err := r.NewCache(&r.Config{
-		NumCounters: 1000000 * 10,
-		MaxCost:     1000000,
-		BufferItems: 64,
+		NumCounters:        1000000 * 10,
+		MaxCost:            opts.MaxCost,
+		BufferItems:        64,
+		IgnoreInternalCost: true,
+		OnEvict: func(item *r.Item) {
+			if e, ok := item.Value.(*entry); ok {
+				cache.evict(e)
+			}
+		},
+		OnReject: func(item *r.Item) {
+			if e, ok := item.Value.(*entry); ok {
+				cache.reject(e)
+			}
+		},
 	})
-	cache := &Cache{
-		name:   name,
-		tp:     tp,
-		opened: true,
-		db:     cacheDB,
-		keys:   make(map[string]int),
+	cache.db = cacheDB
+	if err == nil {
+		go cache.waiter()
 	}
 	return cache, err
 }
 
-func (c *Cache) waitForKey(key []byte, available bool) {
-	for {
-		if _, ok := c.db.Get(key); ok == available {
-			break
-		}
+// OnEvict sets the function called for each key/value evicted from the cache
+func (c *Cache) OnEvict(fn EvictFunc) {
+	c.Lock()
+	defer c.Unlock()
+	c.onEvict = fn
+}
+
+// evict removes the key from the list and notifies the caller
+// an entry replaced by a newer value is ignored
+func (c *Cache) evict(e *entry) {
+	c.Lock()
+	if c.keys[e.key] != e {
+		c.Unlock()
+		return
+	}
+	delete(c.keys, e.key)
+	fn := c.onEvict
+	c.Unlock()
+
+	if fn != nil {
+		fn([]byte(e.key), e.value)
+	}
+}
+
+// reject removes the key from the list, the writer receives ErrRejected
+func (c *Cache) reject(e *entry) {
+	c.Lock()
+	defer c.Unlock()
+	e.rejected = true
+	if c.keys[e.key] == e {
+		delete(c.keys, e.key)
+	}
+}
+
+// waiter calls the ristretto Wait of each request until Close
+// the writes wait with the txn lock, so a timed out write only leaves this
+// goroutine waiting and the next write waits for it
+func (c *Cache) waiter() {
+	for done := range c.waits {
+		c.db.Wait()
+		close(done)
+	}
+}
+
+// wait until all buffered writes are applied by ristretto, the txn lock must be held
+// the closed ristretto has nothing to wait
+func (c *Cache) wait() error {
+	if !c.Open() {
+		return nil
+	}
+	timeout := time.NewTimer(c.Timeout)
+	defer timeout.Stop()
 
-		time.Sleep(20 * time.Millisecond)
+	done := make(chan struct{})
+	select {
+	case c.waits <- done:
+	case <-timeout.C:
+		return ErrTimeout
+	}
+	select {
+	case <-done:
+		return nil
+	case <-timeout.C:
+		return ErrTimeout
 	}
 }
 
@@ -62,58 +215,101 @@ func (c *Cache) Open() bool {
 
 // Clean all data
 func (c *Cache) Clean() {
-	c.keys = make(map[string]int)
+	c.txn.Lock()
+	defer c.txn.Unlock()
+
+	// the list is reset first, so the cleared key/values aren't notified as evictions
+	c.Lock()
+	c.keys = make(map[string]*entry)
+	c.Unlock()
+
+	// ristretto blocks the clear until the evictions in progress are done
+	// so the lock can't be held here
+	c.db.Clear()
 }
 
 // Get key value from cache
 func (c *Cache) Get(key []byte) ([]byte, error) {
+	c.txn.RLock()
 	value, ok := c.db.Get(key)
+	c.txn.RUnlock()
 	if !ok {
-		return nil, fmt.Errorf("key not found: %v", key)
+		return nil, fmt.Errorf("%w: %v", dbtx.ErrNotFound, key)
 	}
-	return value.([]byte), nil
+	return value.(*entry).value, nil
 }
 
 // Upsert in cache
+// the value is readable when Upsert returns
+// ErrRejected is returned if the ristretto policy declines the key/value
 func (c *Cache) Upsert(key, value []byte) error {
-	cost := int64(len(value))
-	if !c.db.Set(key, value, cost) {
-		return fmt.Errorf("error on set key value bytes in ristretto cache")
+	return c.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Put(key, value)
+	})
+}
+
+func (c *Cache) upsert(key, value []byte) error {
+	e := &entry{key: string(key), value: value}
+	c.add(e)
+
+	if !c.db.Set(key, e, int64(len(value))) {
+		c.reject(e)
+		return ErrDropped
+	}
+
+	if err := c.wait(); err != nil {
+		return err
+	}
+
+	c.RLock()
+	defer c.RUnlock()
+	if e.rejected {
+		return ErrRejected
 	}
-	c.add(key)
-	c.waitForKey(key, true)
 	return nil
 }
 
-func (c *Cache) add(key []byte) {
+func (c *Cache) add(e *entry) {
 	c.Lock()
 	defer c.Unlock()
-	c.keys[string(key)] = 0
+	c.keys[e.key] = e
 }
 
-func (c *Cache) delete(key []byte) {
-	c.Lock()
-	defer c.Unlock()
-
-	delete(c.keys, string(key))
+// entries returns a copy of current entries in cache memory
+// sorted by key like the others drivers
+func (c *Cache) entries() []*entry {
+	c.txn.RLock()
+	defer c.txn.RUnlock()
+	c.RLock()
+	defer c.RUnlock()
+	entries := make([]*entry, 0, len(c.keys))
+	for _, e := range c.keys {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
 }
 
 // Keys return all current keys in cache memory
+// the result is a copy, so it's safe to iterate while the cache changes
 func (c *Cache) Keys() map[string]int {
-	c.Lock()
-	defer c.Unlock()
-	return c.keys
+	c.txn.RLock()
+	defer c.txn.RUnlock()
+	c.RLock()
+	defer c.RUnlock()
+	keys := make(map[string]int, len(c.keys))
+	for k := range c.keys {
+		keys[k] = 0
+	}
+	return keys
 }
 
 // ForEach get many
 func (c *Cache) ForEach(query func([]byte) error) error {
-	for key := range c.Keys() {
-		value, err := c.Get([]byte(key))
-		if err != nil {
-			return err
-		}
-
-		if err := query(value); err != nil {
+	for _, e := range c.entries() {
+		if err := query(e.value); err != nil {
 			return err
 		}
 	}
@@ -123,8 +319,8 @@ func (c *Cache) ForEach(query func([]byte) error) error {
 
 // KeyIterator in current keys cached
 func (c *Cache) KeyIterator(query func([]byte) error) error {
-	for key := range c.Keys() {
-		if err := query([]byte(key)); err != nil {
+	for _, e := range c.entries() {
+		if err := query([]byte(e.key)); err != nil {
 			return err
 		}
 	}
@@ -132,35 +328,152 @@ func (c *Cache) KeyIterator(query func([]byte) error) error {
 }
 
 // Delete the key/value
+// the key is removed from the cache when Delete returns
 func (c *Cache) Delete(key []byte) error {
-	c.delete(key)
-	c.db.Del(key)
-	c.waitForKey(key, false)
+	return c.Update(func(bkt dbtx.Bucket) error {
+		return bkt.Delete(key)
+	})
+}
 
-	return nil
+func (c *Cache) delete(key []byte) error {
+	c.Lock()
+	delete(c.keys, string(key))
+	c.Unlock()
+
+	c.db.Del(key)
+	return c.wait()
 }
 
-// Update updates all database executions inside one transaction, in ristreto nothing change
+// Update updates all database executions inside one transaction
+// the writes are staged and only applied if the execution returns no error
+// if ristretto declines a write, the applied ones are rolled back, the restore
+// is a new write that ristretto can decline too, then a *RollbackError is returned
+// the writes are published to the watchers after the commit
 func (c *Cache) Update(execute dbtx.Execute) error {
-	return execute(dbtx.BucketImp{ // actual implementation of bucket
+	var ops []operation
+	err := execute(dbtx.BucketImp{ // actual implementation of bucket
 		PutImp: func(key []byte, val []byte) error {
-			return c.Upsert(key, val)
+			ops = append(ops, operation{
+				key:   append([]byte{}, key...),
+				value: append([]byte{}, val...),
+			})
+			return nil
 		},
 		DeleteImp: func(key []byte) error {
-			return c.Delete(key)
+			ops = append(ops, operation{key: append([]byte{}, key...), delete: true})
+			return nil
 		},
 	})
+	if err != nil {
+		return err
+	}
+
+	c.txn.Lock()
+	defer c.txn.Unlock()
+	return c.write(ops)
+}
+
+// write commits and publishes the operations, the txn lock must be held
+func (c *Cache) write(ops []operation) error {
+	if err := c.commit(ops); err != nil {
+		return err
+	}
+	c.publish(ops)
+	return nil
+}
+
+// publish the committed operations, the txn lock keeps the order of the commits
+func (c *Cache) publish(ops []operation) {
+	if !c.hub.Watching() {
+		return
+	}
+	events := make([]watch.Event, 0, len(ops))
+	for _, op := range ops {
+		if op.delete {
+			events = append(events, watch.Event{Op: watch.Delete, Key: op.key})
+		} else {
+			events = append(events, watch.Event{Op: watch.Put, Key: op.key, Value: op.value})
+		}
+	}
+	c.hub.Publish(events...)
+}
+
+// Watch returns the events of the keys with the prefix, committed after Watch returns
+// the evictions aren't published, they can be followed by OnEvict
+// the slow subscribers are dropped, see the watch package
+func (c *Cache) Watch(prefix []byte) (<-chan watch.Event, func()) {
+	return c.hub.Watch(prefix)
+}
+
+// commit applies the operations in order
+// on error, the previous values of the changed keys are restored
+// a *RollbackError is returned if they can't be restored
+func (c *Cache) commit(ops []operation) error {
+	previous := make([]operation, 0, len(ops))
+	for _, op := range ops {
+		c.RLock()
+		e, ok := c.keys[string(op.key)]
+		c.RUnlock()
+
+		if ok {
+			previous = append(previous, operation{key: op.key, value: e.value})
+		} else {
+			previous = append(previous, operation{key: op.key, delete: true})
+		}
+
+		if err := c.apply(op); err != nil {
+			if rerr := c.rollback(previous); rerr != nil {
+				return &RollbackError{Err: err, Rollback: rerr}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback restores the previous values in reverse order
+// all values are restored, it returns the first error
+func (c *Cache) rollback(previous []operation) error {
+	var first error
+	for i := len(previous) - 1; i >= 0; i-- {
+		if err := c.apply(previous[i]); err != nil && first == nil {
+			first = fmt.Errorf("%q: %w", previous[i].key, err)
+		}
+	}
+	return first
+}
+
+func (c *Cache) apply(op operation) error {
+	if op.delete {
+		return c.delete(op.key)
+	}
+	return c.upsert(op.key, op.value)
 }
 
 // Close the database
+// the channels of the watchers are closed
 func (c *Cache) Close() error {
+	c.txn.Lock()
+	defer c.txn.Unlock()
+
+	c.Lock()
+	opened := c.opened
 	c.opened = false
+	c.keys = make(map[string]*entry)
+	c.Unlock()
+
+	c.hub.Close()
+	if opened {
+		close(c.waits)
+	}
 	c.db.Close()
 	return nil
 }
 
 // Length amount of keys in database
 func (c *Cache) Length() int {
+	c.RLock()
+	defer c.RUnlock()
 	return len(c.keys)
 }
 
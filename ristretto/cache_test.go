@@ -1,9 +1,11 @@
 package ristretto_test
 
 import (
+	"github.com/plateausnetwork/drivers/dbtx"
 	r "github.com/plateausnetwork/drivers/ristretto"
 
 	"bytes"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
@@ -137,3 +139,148 @@ func TestDBInformations(t *testing.T) {
 		}
 	})
 }
+
+func TestClean(t *testing.T) {
+	withCache(func(cache *r.Cache) {
+		cache.Clean()
+
+		if cache.Length() != 0 {
+			t.Error("the length must be zero after clean")
+		}
+
+		if _, err := cache.Get(key); err == nil {
+			t.Error("the value must be removed from cache after clean")
+		}
+
+		// the cache must still work after clean
+		if err := cache.Upsert(key, value); err != nil {
+			t.Error(err)
+		}
+
+		if cache.Length() != 1 {
+			t.Error("the length must be one after insert")
+		}
+	})
+}
+
+func TestRejected(t *testing.T) {
+	withCache(func(cache *r.Cache) {
+		// the cost is the size of the value, bigger than the whole cache
+		big := make([]byte, 1000001)
+		if err := cache.Upsert([]byte("big"), big); err != r.ErrRejected {
+			t.Errorf("expected %v, received %v", r.ErrRejected, err)
+		}
+
+		if _, err := cache.Get([]byte("big")); err == nil {
+			t.Error("a rejected key must not be available")
+		}
+
+		if cache.Length() != 1 {
+			t.Error("a rejected key must not be counted")
+		}
+	})
+}
+
+func TestEvictions(t *testing.T) {
+	withCache(func(cache *r.Cache) {
+		evicted := 0
+		cache.OnEvict(func(k, v []byte) {
+			evicted++
+		})
+
+		// fill the cache more than its max cost
+		total, rejected := 1, 0
+		for i := 0; i < 30; i++ {
+			err := cache.Upsert([]byte(fmt.Sprintf("k%d", i)), make([]byte, 100000))
+			switch err {
+			case nil:
+				total++
+			case r.ErrRejected:
+				rejected++
+			default:
+				t.Fatal(err)
+			}
+		}
+
+		if evicted == 0 && rejected == 0 {
+			t.Error("the cache must evict or reject key/values when full")
+		}
+
+		if cache.Length() != total-evicted {
+			t.Errorf("length %d must be the inserted keys %d minus the evicted %d", cache.Length(), total, evicted)
+		}
+
+		// all listed keys must be readable
+		if err := cache.KeyIterator(func(k []byte) error {
+			_, err := cache.Get(k)
+			return err
+		}); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	withCache(func(cache *r.Cache) {
+		err := cache.Update(func(bkt dbtx.Bucket) error {
+			if err := bkt.Put([]byte("k2"), []byte("v2")); err != nil {
+				return err
+			}
+			return bkt.Delete(key)
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := cache.Get(key); err == nil {
+			t.Error("the deleted key must not be available")
+		}
+
+		if v, err := cache.Get([]byte("k2")); err != nil || !bytes.Equal(v, []byte("v2")) {
+			t.Error("the inserted key must be available")
+		}
+	})
+}
+
+func TestUpdateRollback(t *testing.T) {
+	withCache(func(cache *r.Cache) {
+		// the execution returns an error, nothing is applied
+		err := cache.Update(func(bkt dbtx.Bucket) error {
+			bkt.Put(key, []byte("changed")) // nolint
+			bkt.Put([]byte("k2"), value)    // nolint
+			return fmt.Errorf("test error")
+		})
+		if err == nil {
+			t.Error("the error of the execution must be returned")
+		}
+
+		if v, err := cache.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Error("the value must not change after a rollback")
+		}
+
+		// the cache declines the last write, the first is rolled back
+		err = cache.Update(func(bkt dbtx.Bucket) error {
+			bkt.Put(key, []byte("changed")) // nolint
+			return bkt.Put([]byte("big"), make([]byte, 1000001))
+		})
+		if err != r.ErrRejected {
+			t.Errorf("expected %v, received %v", r.ErrRejected, err)
+		}
+
+		if v, err := cache.Get(key); err != nil || !bytes.Equal(v, value) {
+			t.Error("the value must be restored after a rejected write")
+		}
+
+		if cache.Length() != 1 {
+			t.Error("the length must not change after a rollback")
+		}
+	})
+}
+
+func TestRollbackError(t *testing.T) {
+	err := error(&r.RollbackError{Err: r.ErrRejected, Rollback: r.ErrTimeout})
+	if !errors.Is(err, r.ErrRejected) || errors.Is(err, r.ErrTimeout) {
+		t.Errorf("the rollback error must match the error of the write: %v", err)
+	}
+}
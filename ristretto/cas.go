@@ -0,0 +1,57 @@
+package ristretto
+
+import (
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// current returns the value of the key and false if it doesn't exist
+func (c *Cache) current(key []byte) ([]byte, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	e, ok := c.keys[string(key)]
+	if !ok {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// CompareAndSwap writes the value if the current one is the expected
+// expected nil means that the key must not exist and value nil deletes the key
+// a dbtx.PreconditionError is returned if the current value isn't the expected
+// the writes are blocked from the check until the value is applied
+func (c *Cache) CompareAndSwap(key, expected, value []byte) error {
+	c.txn.Lock()
+	defer c.txn.Unlock()
+
+	cur, found := c.current(key)
+	if err := dbtx.CheckSwap(key, cur, found, expected); err != nil {
+		return err
+	}
+	op := operation{key: append([]byte{}, key...), delete: value == nil}
+	if value != nil {
+		op.value = append([]byte{}, value...)
+	}
+	return c.write([]operation{op})
+}
+
+// PutIfAbsent writes the key/value if the key doesn't exist
+func (c *Cache) PutIfAbsent(key, value []byte) error {
+	return c.CompareAndSwap(key, nil, value)
+}
+
+// Increment adds delta to the counter of the key
+// the counter of a key that doesn't exist or was evicted is zero
+func (c *Cache) Increment(key []byte, delta int64) (int64, error) {
+	c.txn.Lock()
+	defer c.txn.Unlock()
+
+	cur, found := c.current(key)
+	n, err := dbtx.AddCounter(key, cur, found, delta)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.write([]operation{{key: append([]byte{}, key...), value: dbtx.EncodeCounter(n)}}); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
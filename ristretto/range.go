@@ -0,0 +1,57 @@
+package ristretto
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// DeletePrefix deletes the keys with the prefix, inside one transaction
+func (c *Cache) DeletePrefix(prefix []byte) (int, error) {
+	return c.DeleteRange(prefix, dbtx.PrefixEnd(prefix))
+}
+
+// DeleteRange deletes the keys from start until end (exclusive), inside one transaction
+// end nil deletes until the last key
+// the keys are found in the list of keys, the writes are blocked until the deletes are applied
+func (c *Cache) DeleteRange(start, end []byte) (int, error) {
+	c.txn.Lock()
+	defer c.txn.Unlock()
+
+	c.Lock()
+	var keys []string
+	for key := range c.keys {
+		if key >= string(start) && (end == nil || key < string(end)) {
+			keys = append(keys, key)
+			delete(c.keys, key)
+		}
+	}
+	c.Unlock()
+	sort.Strings(keys)
+
+	// one wait for all deletes
+	ops := make([]operation, len(keys))
+	for i, key := range keys {
+		ops[i] = operation{key: []byte(key), delete: true}
+		c.db.Del(ops[i].key)
+	}
+	if err := c.wait(); err != nil {
+		return 0, err
+	}
+	c.publish(ops)
+	return len(ops), nil
+}
+
+// CountPrefix returns the amount of keys with the prefix in cache memory
+func (c *Cache) CountPrefix(prefix []byte) (int, error) {
+	c.RLock()
+	defer c.RUnlock()
+	count := 0
+	for key := range c.keys {
+		if strings.HasPrefix(key, string(prefix)) {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,45 @@
+package ristretto
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// Scan returns a page of the key/values in cache memory, see dbtx.ScanOptions
+func (c *Cache) Scan(opts dbtx.ScanOptions) (*dbtx.Page, error) {
+	after, err := dbtx.DecodeToken(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := c.entries()
+	prefix := string(opts.Prefix)
+
+	// range of the prefix [first, end) in the sorted entries
+	first := sort.Search(len(entries), func(i int) bool { return entries[i].key >= prefix })
+	end := first
+	for end < len(entries) && strings.HasPrefix(entries[end].key, prefix) {
+		end++
+	}
+
+	pager := dbtx.NewPager(opts)
+	if opts.Reverse {
+		if after != nil {
+			end = sort.Search(len(entries), func(i int) bool { return entries[i].key >= string(after) })
+		}
+		for i := end - 1; i >= first && !pager.Full(); i-- {
+			pager.Add([]byte(entries[i].key), entries[i].value)
+		}
+		return pager.Page(), nil
+	}
+
+	if after != nil {
+		first = sort.Search(len(entries), func(i int) bool { return entries[i].key > string(after) })
+	}
+	for i := first; i < end && !pager.Full(); i++ {
+		pager.Add([]byte(entries[i].key), entries[i].value)
+	}
+	return pager.Page(), nil
+}
@@ -0,0 +1,41 @@
+package ristretto
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// sequence in memory, the ids start at 1
+type sequence struct {
+	last uint64
+}
+
+// Next id of the sequence
+func (seq *sequence) Next() (uint64, error) {
+	return atomic.AddUint64(&seq.last, 1), nil
+}
+
+// Release nothing, the ids aren't leased
+func (seq *sequence) Release() error {
+	return nil
+}
+
+// Sequence returns the sequence of the name
+// the sequences are kept in memory, out of the cache, so they aren't evicted
+// but they are lost with the cache like the key/values
+func (c *Cache) Sequence(name []byte) (dbtx.Sequence, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("ristretto: empty sequence name")
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	seq, ok := c.seqs[string(name)]
+	if !ok {
+		seq = &sequence{}
+		c.seqs[string(name)] = seq
+	}
+	return seq, nil
+}
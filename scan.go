@@ -0,0 +1,74 @@
+package drivers
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// errStop ends the iteration of keys in Scan
+var errStop = errors.New("stop")
+
+// Scan returns a page of key/values of the database
+// the drivers without Scanner iterate the keys and get the values of the page
+func Scan(db Reader, opts ScanOptions) (*Page, error) {
+	if scanner, ok := db.(Scanner); ok {
+		return scanner.Scan(opts)
+	}
+
+	after, err := dbtx.DecodeToken(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the reverse order needs all keys with the prefix before the token
+	var keys [][]byte
+	err = db.KeyIterator(func(key []byte) error {
+		if !bytes.HasPrefix(key, opts.Prefix) {
+			return nil
+		}
+		if opts.Reverse {
+			if after != nil && bytes.Compare(key, after) >= 0 {
+				return errStop
+			}
+		} else if after != nil && bytes.Compare(key, after) <= 0 {
+			return nil
+		}
+		keys = append(keys, key)
+		if !opts.Reverse && opts.Limit > 0 && len(keys) > opts.Limit {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	if opts.Reverse {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	// the token is the last key of the page iterated, even if it was deleted after
+	more := opts.Limit > 0 && len(keys) > opts.Limit
+	if more {
+		keys = keys[:opts.Limit]
+	}
+	pager := dbtx.NewPager(opts)
+	for _, key := range keys {
+		value, err := db.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			continue // deleted after the iteration
+		}
+		if err != nil {
+			return nil, err
+		}
+		pager.Add(key, value)
+	}
+	page := pager.Page()
+	if more {
+		page.Token = dbtx.EncodeToken(keys[len(keys)-1], opts.Reverse)
+	}
+	return page, nil
+}
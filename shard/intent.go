@@ -0,0 +1,55 @@
+package shard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Prefix of the keys of the shards, the key/values must not start with it
+var Prefix = []byte("\xffshard\x00")
+
+// intentKey stores the previous values of the Update of many shards
+var intentKey = append(append([]byte{}, Prefix...), "intent"...)
+
+// IsShardKey returns true if the key is reserved by the shards
+func IsShardKey(key []byte) bool {
+	return bytes.HasPrefix(key, Prefix)
+}
+
+// encodeOps as flag, key and value, each with its uvarint length
+// the flag is 1 for the deletes
+func encodeOps(ops []op) []byte {
+	var buf []byte
+	var n [binary.MaxVarintLen64]byte
+	for _, o := range ops {
+		flag := byte(0)
+		if o.delete {
+			flag = 1
+		}
+		buf = append(buf, flag)
+		for _, data := range [][]byte{o.key, o.value} {
+			buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(data)))]...)
+			buf = append(buf, data...)
+		}
+	}
+	return buf
+}
+
+func decodeOps(buf []byte) ([]op, error) {
+	var ops []op
+	for len(buf) > 0 {
+		o := op{delete: buf[0] == 1}
+		buf = buf[1:]
+		for _, data := range []*[]byte{&o.key, &o.value} {
+			size, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < size {
+				return nil, fmt.Errorf("shard: corrupted intent")
+			}
+			*data = buf[n : n+int(size)]
+			buf = buf[n+int(size):]
+		}
+		ops = append(ops, o)
+	}
+	return ops, nil
+}
@@ -0,0 +1,131 @@
+package shard
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// Placement returns the shard of each key
+// Shards: amount of shards of the placement
+type Placement interface {
+	Shard(key []byte) int
+	Shards() int
+}
+
+// DefaultReplicas is the amount of points of each shard in the ring
+const DefaultReplicas = 128
+
+// Ring of consistent hashing, each shard has points in the ring and the key
+// is in the shard of the next point after its hash
+// a ring with one more shard only moves the keys to the new shard
+type Ring struct {
+	points []uint64 // sorted
+	owners []int    // shard of each point
+	shards int
+}
+
+type point struct {
+	hash  uint64
+	shard int
+}
+
+// NewRing returns the ring of the shards, with DefaultReplicas if replicas is zero
+func NewRing(shards, replicas int) (*Ring, error) {
+	if shards < 1 {
+		return nil, fmt.Errorf("shard: invalid amount of shards %d", shards)
+	}
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	points := make([]point, 0, shards*replicas)
+	var buf [16]byte
+	for s := 0; s < shards; s++ {
+		for r := 0; r < replicas; r++ {
+			binary.BigEndian.PutUint64(buf[:8], uint64(s))
+			binary.BigEndian.PutUint64(buf[8:], uint64(r))
+			points = append(points, point{hash: hash(buf[:]), shard: s})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	ring := &Ring{shards: shards}
+	for _, p := range points {
+		ring.points = append(ring.points, p.hash)
+		ring.owners = append(ring.owners, p.shard)
+	}
+	return ring, nil
+}
+
+// hash of fnv-1a with the finalizer of splitmix64, so the close keys are spread
+func hash(buf []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(buf) // nolint
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// Shard of the key
+func (r *Ring) Shard(key []byte) int {
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[i]
+}
+
+// Shards of the ring
+func (r *Ring) Shards() int {
+	return r.shards
+}
+
+// Range of keys from Start until the Start of the next range
+type Range struct {
+	Start []byte
+	Shard int
+}
+
+// Ranges of keys in ascending order of Start, the first one starts with the empty key
+// a shard may have many ranges, so a new shard can receive a range from any other
+type Ranges []Range
+
+// NewRanges validates the ranges
+func NewRanges(ranges ...Range) (Ranges, error) {
+	if len(ranges) == 0 || len(ranges[0].Start) != 0 {
+		return nil, errors.New("shard: the first range must start with the empty key")
+	}
+	for i, r := range ranges {
+		if r.Shard < 0 {
+			return nil, fmt.Errorf("shard: invalid shard %d", r.Shard)
+		}
+		if i > 0 && bytes.Compare(ranges[i-1].Start, r.Start) >= 0 {
+			return nil, fmt.Errorf("shard: the range %q isn't after %q", r.Start, ranges[i-1].Start)
+		}
+	}
+	return Ranges(ranges), nil
+}
+
+// Shard of the last range starting before or at the key
+func (r Ranges) Shard(key []byte) int {
+	i := sort.Search(len(r), func(i int) bool { return bytes.Compare(r[i].Start, key) > 0 })
+	return r[i-1].Shard
+}
+
+// Shards returns the greatest shard of the ranges plus one
+func (r Ranges) Shards() int {
+	n := 0
+	for _, rng := range r {
+		if rng.Shard >= n {
+			n = rng.Shard + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,613 @@
+/*
+	Package shard implements a drivers.KeyValueDB that spreads the keys over
+	many databases, so the writes in different shards don't wait the same lock.
+	The shard of each key is given by a Placement: a ring of consistent hashing
+	or ranges of keys.
+	The iterations merge the shards in ascending order of keys, they aren't a
+	snapshot of all shards. An Update with keys of many shards keeps the
+	previous values in an intent of the first shard until all of them are
+	written, so it's rolled back if one of them fails.
+	AddShard moves the keys to the new placement, the reads and writes wait
+	until it's done.
+*/
+
+package shard
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// pageSize of the scans of each shard in the iterations
+var pageSize = 256
+
+// RebalanceBatch is the amount of key/values moved in each transaction of Rebalance
+var RebalanceBatch = 1000
+
+// DB of the shards
+type DB struct {
+	shards    []drivers.KeyValueDB
+	placement Placement
+	mu        sync.RWMutex // the lock is held by AddShard and Rebalance, the user code runs without it
+}
+
+// New returns the database of the shards, the placement must not have more shards
+// the keys written with another placement must be moved by Rebalance
+// the Update interrupted by a crash is rolled back
+func New(shards []drivers.KeyValueDB, placement Placement) (*DB, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("shard: no shards")
+	}
+	if placement.Shards() < 1 || placement.Shards() > len(shards) {
+		return nil, fmt.Errorf("shard: the placement has %d shards, got %d", placement.Shards(), len(shards))
+	}
+	db := &DB{shards: append([]drivers.KeyValueDB{}, shards...), placement: placement}
+	if err := db.recover(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// shard of the key, the lock must be held
+func (db *DB) shard(key []byte) drivers.KeyValueDB {
+	return db.shards[db.placement.Shard(key)]
+}
+
+// Shards returns the databases of the shards
+func (db *DB) Shards() []drivers.KeyValueDB {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return append([]drivers.KeyValueDB{}, db.shards...)
+}
+
+// Type of the first shard
+func (db *DB) Type() int {
+	return db.shards[0].Type()
+}
+
+// Path of the first shard
+func (db *DB) Path() string {
+	return db.shards[0].Path()
+}
+
+// each calls fn with each shard and returns the first error, after all shards
+func (db *DB) each(fn func(drivers.KeyValueDB) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var first error
+	for i, s := range db.shards {
+		if err := fn(s); err != nil && first == nil {
+			first = fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return first
+}
+
+// Clean all shards
+func (db *DB) Clean() {
+	db.each(func(s drivers.KeyValueDB) error { //nolint:errcheck
+		s.Clean()
+		return nil
+	})
+}
+
+// Open returns true if all shards are open
+func (db *DB) Open() bool {
+	open := true
+	db.each(func(s drivers.KeyValueDB) error { //nolint:errcheck
+		open = open && s.Open()
+		return nil
+	})
+	return open
+}
+
+// Size of all shards
+func (db *DB) Size() (int64, error) {
+	var size int64
+	err := db.each(func(s drivers.KeyValueDB) error {
+		n, err := s.Size()
+		size += n
+		return err
+	})
+	return size, err
+}
+
+// Length amount of keys of all shards
+func (db *DB) Length() int {
+	var length int
+	db.each(func(s drivers.KeyValueDB) error { //nolint:errcheck
+		length += s.Length()
+		return nil
+	})
+	// the intent of a failed rollback
+	if n, err := drivers.CountPrefix(db.shards[0], Prefix); err == nil {
+		length -= n
+	}
+	return length
+}
+
+// Close all shards
+func (db *DB) Close() error {
+	return db.each(func(s drivers.KeyValueDB) error {
+		return s.Close()
+	})
+}
+
+// CreateBuckets in all shards
+func (db *DB) CreateBuckets(buckets ...[]byte) error {
+	return db.each(func(s drivers.KeyValueDB) error {
+		return s.CreateBuckets(buckets...)
+	})
+}
+
+// DeleteBuckets of all shards
+func (db *DB) DeleteBuckets(buckets ...[]byte) error {
+	return db.each(func(s drivers.KeyValueDB) error {
+		return s.DeleteBuckets(buckets...)
+	})
+}
+
+// Get the value of the key in its shard
+func (db *DB) Get(key []byte) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.shard(key).Get(key)
+}
+
+// Upsert the key/value in its shard
+func (db *DB) Upsert(key, value []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.shard(key).Upsert(key, value)
+}
+
+// Delete the key/value of its shard
+func (db *DB) Delete(key []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.shard(key).Delete(key)
+}
+
+// CompareAndSwap in the shard of the key
+func (db *DB) CompareAndSwap(key, expected, value []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.shard(key).CompareAndSwap(key, expected, value)
+}
+
+// PutIfAbsent in the shard of the key
+func (db *DB) PutIfAbsent(key, value []byte) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.shard(key).PutIfAbsent(key, value)
+}
+
+// Increment the counter in the shard of the key
+func (db *DB) Increment(key []byte, delta int64) (int64, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.shard(key).Increment(key, delta)
+}
+
+// op is a staged write of Update, delete or put the value
+type op struct {
+	key, value []byte
+	delete     bool
+}
+
+// Update stages the writes and commits them in one transaction of each shard
+// the writes of one shard are committed with the read lock, the writes of many
+// shards wait the lock and keep the previous values in the intent of the first
+// shard until all shards are written, so they're rolled back if a shard fails
+// and by New after a crash
+func (db *DB) Update(execute dbtx.Execute) error {
+	var ops []op
+	err := execute(dbtx.BucketImp{
+		PutImp: func(key, value []byte) error {
+			if IsShardKey(key) {
+				return fmt.Errorf("shard: the key %q uses the reserved prefix", key)
+			}
+			ops = append(ops, op{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+			return nil
+		},
+		DeleteImp: func(key []byte) error {
+			if IsShardKey(key) {
+				return fmt.Errorf("shard: the key %q uses the reserved prefix", key)
+			}
+			ops = append(ops, op{key: append([]byte{}, key...), delete: true})
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	db.mu.RLock()
+	if byShard := db.split(ops); len(byShard) <= 1 {
+		defer db.mu.RUnlock()
+		return db.apply(byShard)
+	}
+	db.mu.RUnlock()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commit(db.split(ops))
+}
+
+// split the writes by their shards, the lock must be held
+func (db *DB) split(ops []op) map[int][]op {
+	byShard := make(map[int][]op)
+	for _, o := range ops {
+		i := db.placement.Shard(o.key)
+		byShard[i] = append(byShard[i], o)
+	}
+	return byShard
+}
+
+// apply the writes in one transaction of each shard, in the order of the shards
+func (db *DB) apply(byShard map[int][]op) error {
+	for i := range db.shards {
+		list, ok := byShard[i]
+		if !ok {
+			continue
+		}
+		err := db.shards[i].Update(func(bkt dbtx.Bucket) error {
+			for _, o := range list {
+				var err error
+				if o.delete {
+					err = bkt.Delete(o.key)
+				} else {
+					err = bkt.Put(o.key, o.value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// commit the writes of many shards with the intent of the previous values
+// the lock must be held
+func (db *DB) commit(byShard map[int][]op) error {
+	if err := db.recover(); err != nil {
+		return err
+	}
+
+	var undo []op
+	seen := make(map[string]bool)
+	for i, list := range byShard {
+		for _, o := range list {
+			if seen[string(o.key)] {
+				continue
+			}
+			seen[string(o.key)] = true
+			value, err := db.shards[i].Get(o.key)
+			switch {
+			case errors.Is(err, drivers.ErrNotFound):
+				undo = append(undo, op{key: o.key, delete: true})
+			case err != nil:
+				return fmt.Errorf("shard %d: %w", i, err)
+			default:
+				undo = append(undo, op{key: o.key, value: value})
+			}
+		}
+	}
+	if err := db.shards[0].Upsert(intentKey, encodeOps(undo)); err != nil {
+		return fmt.Errorf("shard 0: %w", err)
+	}
+
+	if err := db.apply(byShard); err != nil {
+		if rerr := db.recover(); rerr != nil {
+			return fmt.Errorf("%w, on rolling back: %v", err, rerr)
+		}
+		return err
+	}
+	if err := db.shards[0].Delete(intentKey); err != nil {
+		return fmt.Errorf("shard 0: %w", err)
+	}
+	return nil
+}
+
+// recover rolls back the writes of the intent left by a failed Update
+// the lock must be held
+func (db *DB) recover() error {
+	buf, err := db.shards[0].Get(intentKey)
+	if errors.Is(err, drivers.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("shard 0: %w", err)
+	}
+	undo, err := decodeOps(buf)
+	if err != nil {
+		return err
+	}
+	if err := db.apply(db.split(undo)); err != nil {
+		return err
+	}
+	if err := db.shards[0].Delete(intentKey); err != nil {
+		return fmt.Errorf("shard 0: %w", err)
+	}
+	return nil
+}
+
+// cursor reads the pages of a shard in the order of the scan
+type cursor struct {
+	db    drivers.KeyValueDB
+	opts  drivers.ScanOptions
+	items []dbtx.KeyValue
+	done  bool
+}
+
+// peek returns the next key/value, nil at the end
+func (c *cursor) peek() (*dbtx.KeyValue, error) {
+	for len(c.items) == 0 && !c.done {
+		page, err := drivers.Scan(c.db, c.opts)
+		if err != nil {
+			return nil, err
+		}
+		c.items, c.opts.Token, c.done = page.Items, page.Token, page.Token == ""
+	}
+	if len(c.items) == 0 {
+		return nil, nil
+	}
+	return &c.items[0], nil
+}
+
+// merge calls fn with the key/values of all shards in the order of the options
+// until fn returns false, the limit of the options is the page of each shard
+func (db *DB) merge(opts drivers.ScanOptions, fn func(dbtx.KeyValue) (bool, error)) error {
+	cursors := make([]*cursor, len(db.shards))
+	for i, s := range db.shards {
+		cursors[i] = &cursor{db: s, opts: opts}
+	}
+	for {
+		var next *cursor
+		var nextKV *dbtx.KeyValue
+		for _, c := range cursors {
+			kv, err := c.peek()
+			if err != nil {
+				return err
+			}
+			if kv == nil {
+				continue
+			}
+			cmp := 0
+			if nextKV != nil {
+				cmp = bytes.Compare(kv.Key, nextKV.Key)
+				if opts.Reverse {
+					cmp = -cmp
+				}
+			}
+			if nextKV == nil || cmp < 0 {
+				next, nextKV = c, kv
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		next.items = next.items[1:]
+		if IsShardKey(nextKV.Key) {
+			continue
+		}
+		if more, err := fn(*nextKV); err != nil || !more {
+			return err
+		}
+	}
+}
+
+// ForEach value of all shards, in ascending order of keys
+// the pages are read with the lock and the query is called without it, so it
+// can call the database
+func (db *DB) ForEach(query func([]byte) error) error {
+	return db.iterate(func(kv dbtx.KeyValue) error {
+		return query(kv.Value)
+	})
+}
+
+// KeyIterator iterates the keys of all shards, in ascending order
+// the query is called without the lock, see ForEach
+func (db *DB) KeyIterator(query func([]byte) error) error {
+	return db.iterate(func(kv dbtx.KeyValue) error {
+		return query(kv.Key)
+	})
+}
+
+// iterate calls fn with the key/values of the pages read by Scan
+func (db *DB) iterate(fn func(dbtx.KeyValue) error) error {
+	opts := drivers.ScanOptions{Limit: pageSize}
+	for {
+		page, err := db.Scan(opts)
+		if err != nil {
+			return err
+		}
+		for _, kv := range page.Items {
+			if err := fn(kv); err != nil {
+				return err
+			}
+		}
+		if page.Token == "" {
+			return nil
+		}
+		opts.Token = page.Token
+	}
+}
+
+// Scan a page of the key/values of all shards, see drivers.Scanner
+// the token is the last key of the page, so it's valid in all shards
+func (db *DB) Scan(opts drivers.ScanOptions) (*drivers.Page, error) {
+	if _, err := dbtx.DecodeToken(opts); err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pager := dbtx.NewPager(opts)
+	shardOpts := opts
+	if shardOpts.Limit <= 0 || shardOpts.Limit > pageSize {
+		shardOpts.Limit = pageSize
+	}
+	err := db.merge(shardOpts, func(kv dbtx.KeyValue) (bool, error) {
+		if pager.Full() {
+			return false, nil
+		}
+		pager.Add(kv.Key, kv.Value)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pager.Page(), nil
+}
+
+// DeletePrefix deletes the keys with the prefix of all shards, see drivers.RangeDeleter
+func (db *DB) DeletePrefix(prefix []byte) (int, error) {
+	var count int
+	err := db.each(func(s drivers.KeyValueDB) error {
+		n, err := drivers.DeletePrefix(s, prefix)
+		count += n
+		return err
+	})
+	return count, err
+}
+
+// DeleteRange deletes the keys of the range of all shards, see drivers.RangeDeleter
+func (db *DB) DeleteRange(start, end []byte) (int, error) {
+	var count int
+	err := db.each(func(s drivers.KeyValueDB) error {
+		n, err := drivers.DeleteRange(s, start, end)
+		count += n
+		return err
+	})
+	return count, err
+}
+
+// CountPrefix returns the amount of keys with the prefix of all shards
+func (db *DB) CountPrefix(prefix []byte) (int, error) {
+	var count int
+	err := db.each(func(s drivers.KeyValueDB) error {
+		n, err := drivers.CountPrefix(s, prefix)
+		count += n
+		return err
+	})
+	return count, err
+}
+
+// AddShard adds the database as the last shard and moves the keys to the placement
+// it returns the amount of keys moved, see Rebalance
+func (db *DB) AddShard(shard drivers.KeyValueDB, placement Placement) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if placement.Shards() < 1 || placement.Shards() > len(db.shards)+1 {
+		return 0, fmt.Errorf("shard: the placement has %d shards, got %d", placement.Shards(), len(db.shards)+1)
+	}
+	db.shards = append(db.shards, shard)
+	db.placement = placement
+	return db.rebalance()
+}
+
+// Rebalance moves the keys that aren't in the shard of the placement and
+// returns how many were moved
+// the keys are moved by batches of RebalanceBatch: one transaction writes
+// them in each shard, if they aren't there, and one deletes them from the
+// source, so a failed Rebalance can run again; the database must not be used
+// until it succeeds
+func (db *DB) Rebalance() (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.rebalance()
+}
+
+// rebalance reads the shards by pages, only a batch is kept in memory
+func (db *DB) rebalance() (int, error) {
+	moved := 0
+	for i, src := range db.shards {
+		opts := drivers.ScanOptions{Limit: pageSize}
+		var batch []dbtx.KeyValue
+		for {
+			page, err := drivers.Scan(src, opts)
+			if err != nil {
+				return moved, fmt.Errorf("shard %d: %w", i, err)
+			}
+			for _, kv := range page.Items {
+				if !IsShardKey(kv.Key) && db.placement.Shard(kv.Key) != i {
+					batch = append(batch, kv)
+				}
+			}
+			// the next pages are after the keys deleted
+			if len(batch) >= RebalanceBatch || page.Token == "" {
+				if err := db.move(i, batch); err != nil {
+					return moved, err
+				}
+				moved += len(batch)
+				batch = batch[:0]
+			}
+			if page.Token == "" {
+				break
+			}
+			opts.Token = page.Token
+		}
+	}
+	return moved, nil
+}
+
+// move the key/values of the source shard to their shards
+func (db *DB) move(src int, batch []dbtx.KeyValue) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	byShard := make([][]dbtx.KeyValue, len(db.shards))
+	for _, kv := range batch {
+		dst := db.placement.Shard(kv.Key)
+		// the value already in the shard was written after a failed rebalance
+		_, err := db.shards[dst].Get(kv.Key)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, drivers.ErrNotFound) {
+			return fmt.Errorf("shard %d: %w", dst, err)
+		}
+		byShard[dst] = append(byShard[dst], kv)
+	}
+
+	for dst, list := range byShard {
+		if len(list) == 0 {
+			continue
+		}
+		err := db.shards[dst].Update(func(bkt dbtx.Bucket) error {
+			for _, kv := range list {
+				if err := bkt.Put(kv.Key, kv.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("shard %d: %w", dst, err)
+		}
+	}
+
+	err := db.shards[src].Update(func(bkt dbtx.Bucket) error {
+		for _, kv := range batch {
+			if err := bkt.Delete(kv.Key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("shard %d: %w", src, err)
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package shard_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/dbtx"
+	"github.com/plateausnetwork/drivers/drivertest"
+	"github.com/plateausnetwork/drivers/runners"
+	"github.com/plateausnetwork/drivers/shard"
+)
+
+var testBucket = []byte("tbucket")
+
+func openShards(dir string, first, n int) ([]drivers.KeyValueDB, error) {
+	opts := drivers.DriverOptions()
+	opts.AddBucket(testBucket)
+	var dbs []drivers.KeyValueDB
+	for i := first; i < first+n; i++ {
+		db, err := drivers.Open(drivers.Boltdb, fmt.Sprintf("%s/%d.db", dir, i), opts)
+		if err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+func withShards(n int, placement shard.Placement, handler func(string, *shard.DB)) {
+	runners.WithTempDir(func(dir string) {
+		dbs, err := openShards(dir, 0, n)
+		if err != nil {
+			panic(err)
+		}
+		db, err := shard.New(dbs, placement)
+		if err != nil {
+			panic(err)
+		}
+		defer db.Close()
+		handler(dir, db)
+	})
+}
+
+func ring(n int) *shard.Ring {
+	r, err := shard.NewRing(n, shard.DefaultReplicas)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func keyOf(i int) []byte {
+	return []byte(fmt.Sprintf("key/%04d", i))
+}
+
+func fill(t *testing.T, db drivers.KeyValueDB, n int) {
+	for i := 0; i < n; i++ {
+		if err := db.Upsert(keyOf(i), keyOf(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func expectOrdered(t *testing.T, db drivers.KeyValueDB, n int) {
+	var keys [][]byte
+	if err := db.KeyIterator(func(k []byte) error {
+		keys = append(keys, k)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != n || db.Length() != n {
+		t.Fatalf("expected %d keys, got %d and length %d", n, len(keys), db.Length())
+	}
+	for i, k := range keys {
+		if !bytes.Equal(k, keyOf(i)) {
+			t.Fatalf("expected the key %s, got %s", keyOf(i), k)
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	drivertest.RunConformance(t, func(dir string) (drivers.KeyValueDB, error) {
+		dbs, err := openShards(dir, 0, 3)
+		if err != nil {
+			return nil, err
+		}
+		return shard.New(dbs, ring(3))
+	}, drivertest.Options{Buckets: true})
+}
+
+func TestRing(t *testing.T) {
+	withShards(4, ring(4), func(_ string, db *shard.DB) {
+		fill(t, db, 2000)
+		expectOrdered(t, db, 2000)
+
+		for i, s := range db.Shards() {
+			// each shard has about a quarter of the keys
+			if n := s.Length(); n < 300 || n > 700 {
+				t.Errorf("shard %d has %d keys", i, n)
+			}
+		}
+
+		page, err := db.Scan(drivers.ScanOptions{Prefix: []byte("key/1"), Limit: 10, Reverse: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 10 || page.Token == "" || !bytes.Equal(page.Items[0].Key, keyOf(1999)) {
+			t.Fatalf("invalid page %+v", page)
+		}
+		next, err := db.Scan(drivers.ScanOptions{Prefix: []byte("key/1"), Limit: 10, Reverse: true, Token: page.Token})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(next.Items[0].Key, keyOf(1989)) {
+			t.Errorf("the next page starts at %s", next.Items[0].Key)
+		}
+
+		if n, err := db.DeletePrefix([]byte("key/00")); err != nil || n != 100 {
+			t.Errorf("expected 100 keys deleted, got %d: %v", n, err)
+		}
+	})
+}
+
+func TestRanges(t *testing.T) {
+	ranges, err := shard.NewRanges(
+		shard.Range{Start: nil, Shard: 0},
+		shard.Range{Start: keyOf(500), Shard: 1},
+		shard.Range{Start: keyOf(1000), Shard: 0},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withShards(2, ranges, func(_ string, db *shard.DB) {
+		fill(t, db, 1500)
+		expectOrdered(t, db, 1500)
+		if n := db.Shards()[1].Length(); n != 500 {
+			t.Errorf("expected 500 keys in the shard 1, got %d", n)
+		}
+	})
+
+	if _, err := shard.NewRanges(shard.Range{Start: []byte("a")}); err == nil {
+		t.Error("the first range must start at the first key")
+	}
+	if _, err := shard.NewRanges(shard.Range{}, shard.Range{Start: []byte("b")}, shard.Range{Start: []byte("a")}); err == nil {
+		t.Error("the ranges must be in order")
+	}
+}
+
+func TestAddShard(t *testing.T) {
+	// many batches of moves
+	defer func(batch int) { shard.RebalanceBatch = batch }(shard.RebalanceBatch)
+	shard.RebalanceBatch = 50
+
+	withShards(2, ring(2), func(dir string, db *shard.DB) {
+		fill(t, db, 1000)
+
+		added, err := openShards(dir, 2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		moved, err := db.AddShard(added[0], ring(3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only the keys of the new shard are moved
+		if n := added[0].Length(); moved != n || moved == 0 || moved > 500 {
+			t.Errorf("moved %d keys, the new shard has %d", moved, n)
+		}
+		expectOrdered(t, db, 1000)
+		for i := 0; i < 1000; i++ {
+			if v, err := db.Get(keyOf(i)); err != nil || !bytes.Equal(v, keyOf(i)) {
+				t.Fatalf("invalid value of %s: %v", keyOf(i), err)
+			}
+		}
+
+		if moved, err := db.Rebalance(); err != nil || moved != 0 {
+			t.Errorf("expected nothing to move, got %d: %v", moved, err)
+		}
+	})
+}
+
+// the queries of the iterations can call the database while AddShard waits
+func TestIterationDuringAddShard(t *testing.T) {
+	withShards(2, ring(2), func(dir string, db *shard.DB) {
+		fill(t, db, 1000)
+		added, err := openShards(dir, 2, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		started := make(chan struct{})
+		iterated, rebalanced := make(chan error, 1), make(chan error, 1)
+		go func() {
+			first := true
+			iterated <- db.KeyIterator(func(k []byte) error {
+				if first {
+					first = false
+					close(started)
+					time.Sleep(50 * time.Millisecond) // AddShard is waiting
+				}
+				_, err := db.Get(k)
+				return err
+			})
+		}()
+		<-started
+		go func() {
+			_, err := db.AddShard(added[0], ring(3))
+			rebalanced <- err
+		}()
+
+		for _, done := range []chan error{iterated, rebalanced} {
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatal(err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the query and AddShard are deadlocked")
+			}
+		}
+		expectOrdered(t, db, 1000)
+	})
+}
+
+// failing shard, its next Updates fail
+type failing struct {
+	drivers.KeyValueDB
+	failures int
+}
+
+var errShard = errors.New("shard failure")
+
+func (f *failing) Update(execute dbtx.Execute) error {
+	if f.failures > 0 {
+		f.failures--
+		return errShard
+	}
+	return f.KeyValueDB.Update(execute)
+}
+
+// the Update of many shards is rolled back if one of them fails
+func TestUpdateRollback(t *testing.T) {
+	runners.WithTempDir(func(dir string) {
+		dbs, err := openShards(dir, 0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second := &failing{KeyValueDB: dbs[1]}
+		db, err := shard.New([]drivers.KeyValueDB{dbs[0], second}, ring(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fill(t, db, 10)
+
+		update := func() error {
+			return db.Update(func(bkt dbtx.Bucket) error {
+				for i := 0; i < 20; i++ {
+					if err := bkt.Put(keyOf(i), []byte("new")); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+		second.failures = 1
+		if err := update(); !errors.Is(err, errShard) {
+			t.Fatalf("expected %v, received %v", errShard, err)
+		}
+		expectOrdered(t, db, 10)
+		for i := 0; i < 10; i++ {
+			if v, err := db.Get(keyOf(i)); err != nil || !bytes.Equal(v, keyOf(i)) {
+				t.Fatalf("the value of %s wasn't rolled back: %s %v", keyOf(i), v, err)
+			}
+		}
+
+		// the rollback fails too, New rolls it back
+		second.failures = 2
+		if err := update(); !errors.Is(err, errShard) {
+			t.Fatalf("expected %v, received %v", errShard, err)
+		}
+		if err := db.Update(func(bkt dbtx.Bucket) error {
+			return bkt.Put(append(append([]byte{}, shard.Prefix...), "key"...), nil)
+		}); err == nil {
+			t.Error("the keys must not use the reserved prefix")
+		}
+		db, err = shard.New(dbs, ring(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectOrdered(t, db, 10)
+
+		if err := update(); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := db.Get(keyOf(15)); err != nil || string(v) != "new" {
+			t.Errorf("the Update wasn't committed: %s %v", v, err)
+		}
+	})
+}
+
+func TestNew(t *testing.T) {
+	if _, err := shard.New(nil, ring(1)); err == nil {
+		t.Error("a database without shards must fail")
+	}
+	if _, err := shard.NewRing(0, 1); err == nil {
+		t.Error("a ring without shards must fail")
+	}
+	runners.WithTempDir(func(dir string) {
+		dbs, err := openShards(dir, 0, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer dbs[0].Close()
+		defer dbs[1].Close()
+		if _, err := shard.New(dbs, ring(3)); err == nil {
+			t.Error("the placement must not have more shards than the databases")
+		}
+		if _, err := shard.New(dbs, shard.Ranges{}); err == nil {
+			t.Error("the placement must have shards")
+		}
+	})
+}
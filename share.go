@@ -0,0 +1,330 @@
+package drivers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+var (
+	// ErrConflictingOptions is returned by OpenShared when the database is open with other options
+	ErrConflictingOptions = errors.New("the database is already open with other options")
+	// ErrNotSupported is returned when the driver hasn't the method or the option
+	ErrNotSupported = dbtx.ErrNotSupported
+	// ErrClosed is returned by a shared database after its Close
+	ErrClosed = errors.New("the database is closed")
+)
+
+// handleKey of the shared databases, the path is absolute except for ristretto
+type handleKey struct {
+	dbtype DriverType
+	path   string
+}
+
+// handle of a database open by OpenShared
+type handle struct {
+	db      KeyValueDB
+	options Options
+	refs    int
+}
+
+// handles of the process, the lock is held while a database is opened
+var handles = struct {
+	open map[handleKey]*handle
+	sync.Mutex
+}{open: make(map[handleKey]*handle)}
+
+// OpenShared returns the database of the driver and path shared by the process
+// the first call opens it and the next ones, with the same options, share it
+// each returned KeyValueDB must be closed, the database is closed by the last one
+// and each one returns ErrClosed after its Close
+// the current bucket of bolt is shared too: CreateBuckets changes it for all of them
+// ErrConflictingOptions is returned if the database is open with other options
+func OpenShared(dbtype DriverType, dbpath string, options Options) (KeyValueDB, error) {
+	key := handleKey{dbtype: dbtype, path: dbpath}
+	if dbtype != Ristretto {
+		abs, err := filepath.Abs(dbpath)
+		if err != nil {
+			return nil, err
+		}
+		key.path = abs
+	}
+
+	handles.Lock()
+	defer handles.Unlock()
+	h, ok := handles.open[key]
+	if ok && !sameOptions(h.options, options) {
+		return nil, fmt.Errorf("%w: %s", ErrConflictingOptions, key.path)
+	}
+	if !ok {
+		db, err := Open(dbtype, dbpath, options)
+		if err != nil {
+			return nil, err
+		}
+		h = &handle{db: db, options: options}
+		handles.open[key] = h
+	}
+	h.refs++
+	return &shared{KeyValueDB: h.db, key: key}, nil
+}
+
+// sameOptions returns true if the databases open with the options are the same
+func sameOptions(a, b Options) bool {
+	return bytes.Equal(a.Bucket, b.Bucket) && a.Size == b.Size && a.Timeout == b.Timeout &&
+		a.ReadOnly == b.ReadOnly && a.InMemory == b.InMemory && a.NoSync == b.NoSync &&
+		a.FileMode == b.FileMode
+}
+
+// shared database of one holder
+// the optional interfaces of the drivers are forwarded, the root functions
+// are used when the driver hasn't them
+type shared struct {
+	KeyValueDB
+	key    handleKey
+	closed int32
+	once   sync.Once
+}
+
+// db returns the shared database, ErrClosed after the Close of the holder
+func (s *shared) db() (KeyValueDB, error) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return nil, ErrClosed
+	}
+	return s.KeyValueDB, nil
+}
+
+// Close releases the database of the holder, the next calls do nothing
+// the database is closed when all holders released it
+func (s *shared) Close() error {
+	var err error
+	s.once.Do(func() {
+		atomic.StoreInt32(&s.closed, 1)
+		handles.Lock()
+		defer handles.Unlock()
+		h := handles.open[s.key]
+		if h.refs--; h.refs > 0 {
+			return
+		}
+		delete(handles.open, s.key)
+		err = h.db.Close()
+	})
+	return err
+}
+
+// Open returns false after the Close of the holder
+func (s *shared) Open() bool {
+	db, err := s.db()
+	return err == nil && db.Open()
+}
+
+// Clean the database, nothing after the Close of the holder
+func (s *shared) Clean() {
+	if db, err := s.db(); err == nil {
+		db.Clean()
+	}
+}
+
+// Size of the database
+func (s *shared) Size() (int64, error) {
+	db, err := s.db()
+	if err != nil {
+		return 0, err
+	}
+	return db.Size()
+}
+
+// Length amount of keys, zero after the Close of the holder
+func (s *shared) Length() int {
+	db, err := s.db()
+	if err != nil {
+		return 0
+	}
+	return db.Length()
+}
+
+// CreateBuckets of the database, the current bucket is changed for all holders
+func (s *shared) CreateBuckets(buckets ...[]byte) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.CreateBuckets(buckets...)
+}
+
+// DeleteBuckets of the database
+func (s *shared) DeleteBuckets(buckets ...[]byte) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.DeleteBuckets(buckets...)
+}
+
+// Get the value of the key
+func (s *shared) Get(key []byte) ([]byte, error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	return db.Get(key)
+}
+
+// ForEach value of the database
+func (s *shared) ForEach(query func([]byte) error) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.ForEach(query)
+}
+
+// KeyIterator iterates the keys of the database
+func (s *shared) KeyIterator(query func([]byte) error) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.KeyIterator(query)
+}
+
+// Upsert the key/value
+func (s *shared) Upsert(key, value []byte) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.Upsert(key, value)
+}
+
+// Delete the key/value
+func (s *shared) Delete(key []byte) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.Delete(key)
+}
+
+// Update the database in one transaction
+func (s *shared) Update(execute dbtx.Execute) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.Update(execute)
+}
+
+// CompareAndSwap the value of the key
+func (s *shared) CompareAndSwap(key, expected, value []byte) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.CompareAndSwap(key, expected, value)
+}
+
+// PutIfAbsent writes the key/value if the key doesn't exist
+func (s *shared) PutIfAbsent(key, value []byte) error {
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return db.PutIfAbsent(key, value)
+}
+
+// Increment the counter of the key
+func (s *shared) Increment(key []byte, delta int64) (int64, error) {
+	db, err := s.db()
+	if err != nil {
+		return 0, err
+	}
+	return db.Increment(key, delta)
+}
+
+// Scan a page of key/values, see Scanner
+func (s *shared) Scan(opts ScanOptions) (*Page, error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	return Scan(db, opts)
+}
+
+// DeletePrefix deletes the keys with the prefix, see RangeDeleter
+func (s *shared) DeletePrefix(prefix []byte) (int, error) {
+	db, err := s.db()
+	if err != nil {
+		return 0, err
+	}
+	return DeletePrefix(db, prefix)
+}
+
+// DeleteRange deletes the keys from start until end, see RangeDeleter
+func (s *shared) DeleteRange(start, end []byte) (int, error) {
+	db, err := s.db()
+	if err != nil {
+		return 0, err
+	}
+	return DeleteRange(db, start, end)
+}
+
+// CountPrefix returns the amount of keys with the prefix, see RangeDeleter
+func (s *shared) CountPrefix(prefix []byte) (int, error) {
+	db, err := s.db()
+	if err != nil {
+		return 0, err
+	}
+	return CountPrefix(db, prefix)
+}
+
+// Sequence returns the sequence of the name, see Sequencer
+func (s *shared) Sequence(name []byte) (Sequence, error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	if sequencer, ok := db.(Sequencer); ok {
+		return sequencer.Sequence(name)
+	}
+	return nil, ErrNotSupported
+}
+
+// Watch the keys with the prefix, see Watcher
+// the channel is closed if the driver doesn't publish its changes or after
+// the Close of the holder
+func (s *shared) Watch(prefix []byte) (<-chan Event, func()) {
+	if db, err := s.db(); err == nil {
+		if watcher, ok := db.(Watcher); ok {
+			return watcher.Watch(prefix)
+		}
+	}
+	events := make(chan Event)
+	close(events)
+	return events, func() {}
+}
+
+// Compact the database, see Compactor
+func (s *shared) Compact() (*Compaction, error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	if compactor, ok := db.(Compactor); ok {
+		return compactor.Compact()
+	}
+	return nil, ErrNotSupported
+}
+
+// Verify the database, see Verifier
+func (s *shared) Verify() (*Verification, error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	return Verify(db)
+}
@@ -0,0 +1,90 @@
+package typed
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// IDs of the built-in codecs, custom codecs must use others IDs
+const (
+	RawID byte = iota + 1
+	JSONID
+	GobID
+)
+
+// Codec marshals the objects stored in the database
+// ID is written in the header of each value, so it must never change
+type Codec interface {
+	ID() byte
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+// built-in codecs
+var (
+	Raw  Codec = rawCodec{}
+	JSON Codec = jsonCodec{}
+	Gob  Codec = gobCodec{}
+)
+
+// rawCodec stores []byte as it is
+type rawCodec struct{}
+
+func (rawCodec) ID() byte {
+	return RawID
+}
+
+func (rawCodec) Marshal(obj interface{}) ([]byte, error) {
+	switch v := obj.(type) {
+	case []byte:
+		return v, nil
+	case *[]byte:
+		return *v, nil
+	}
+	return nil, fmt.Errorf("typed: raw codec can't marshal %T", obj)
+}
+
+func (rawCodec) Unmarshal(data []byte, obj interface{}) error {
+	v, ok := obj.(*[]byte)
+	if !ok {
+		return fmt.Errorf("typed: raw codec can't unmarshal into %T", obj)
+	}
+	*v = append((*v)[:0], data...)
+	return nil
+}
+
+// jsonCodec uses encoding/json
+type jsonCodec struct{}
+
+func (jsonCodec) ID() byte {
+	return JSONID
+}
+
+func (jsonCodec) Marshal(obj interface{}) ([]byte, error) {
+	return json.Marshal(obj)
+}
+
+func (jsonCodec) Unmarshal(data []byte, obj interface{}) error {
+	return json.Unmarshal(data, obj)
+}
+
+// gobCodec uses encoding/gob, each value has its own type description
+type gobCodec struct{}
+
+func (gobCodec) ID() byte {
+	return GobID
+}
+
+func (gobCodec) Marshal(obj interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(obj); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, obj interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(obj)
+}
@@ -0,0 +1,146 @@
+/*
+	Package typed implements a store of objects on top of drivers.KeyValueDB.
+	The objects are marshaled by a Codec and each value has a header with the
+	codec ID and the format version, so the values written with older codecs or
+	versions can still be read.
+*/
+
+package typed
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/plateausnetwork/drivers"
+)
+
+var (
+	// ErrUnknownCodec is returned when the codec of the value isn't known by the store
+	ErrUnknownCodec = errors.New("typed: unknown codec")
+	// ErrInvalidValue is returned when the value has no header
+	ErrInvalidValue = errors.New("typed: invalid value")
+)
+
+// header length: codec id + version
+const headerLen = 2
+
+// UpgradeFunc converts the data written with an older version to the current one
+// the data is still marshaled by the codec of the value
+type UpgradeFunc func(version byte, data []byte) ([]byte, error)
+
+// Options of the store
+// Codec: used to write the objects
+// Version: format version of the objects written
+// Upgrade: called for the values with an older version, nil keeps the data as it is
+// Codecs: custom codecs used only to read, the built-in are always available
+type Options struct {
+	Codec   Codec
+	Version byte
+	Upgrade UpgradeFunc
+	Codecs  []Codec
+}
+
+// Store of objects
+type Store struct {
+	db     drivers.KeyValueDB
+	opts   Options
+	codecs map[byte]Codec
+}
+
+// New returns the store on top of the database
+func New(db drivers.KeyValueDB, opts Options) (*Store, error) {
+	if opts.Codec == nil {
+		return nil, fmt.Errorf("typed: codec is required")
+	}
+
+	codecs := map[byte]Codec{RawID: Raw, JSONID: JSON, GobID: Gob}
+	for _, c := range append([]Codec{opts.Codec}, opts.Codecs...) {
+		if c == Raw || c == JSON || c == Gob {
+			continue
+		}
+		if _, ok := codecs[c.ID()]; ok {
+			return nil, fmt.Errorf("typed: duplicated codec id %d", c.ID())
+		}
+		codecs[c.ID()] = c
+	}
+
+	return &Store{db: db, opts: opts, codecs: codecs}, nil
+}
+
+// DB returns the database of the store
+func (s *Store) DB() drivers.KeyValueDB {
+	return s.db
+}
+
+// Encode the object with the header
+// it can be used to put objects inside of Update transactions
+func (s *Store) Encode(obj interface{}) ([]byte, error) {
+	data, err := s.opts.Codec.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{s.opts.Codec.ID(), s.opts.Version}, data...), nil
+}
+
+// Decode the value written by Encode into the object
+func (s *Store) Decode(value []byte, obj interface{}) error {
+	if len(value) < headerLen {
+		return ErrInvalidValue
+	}
+
+	codec, ok := s.codecs[value[0]]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownCodec, value[0])
+	}
+
+	data := value[headerLen:]
+	if version := value[1]; version < s.opts.Version && s.opts.Upgrade != nil {
+		var err error
+		if data, err = s.opts.Upgrade(version, data); err != nil {
+			return err
+		}
+	}
+	return codec.Unmarshal(data, obj)
+}
+
+// Put the object
+func (s *Store) Put(key []byte, obj interface{}) error {
+	value, err := s.Encode(obj)
+	if err != nil {
+		return err
+	}
+	return s.db.Upsert(key, value)
+}
+
+// Get the object, obj must be a pointer
+func (s *Store) Get(key []byte, obj interface{}) error {
+	value, err := s.db.Get(key)
+	if err != nil {
+		return err
+	}
+	return s.Decode(value, obj)
+}
+
+// Delete the object
+func (s *Store) Delete(key []byte) error {
+	return s.db.Delete(key)
+}
+
+// Value is a stored object not decoded yet
+type Value struct {
+	store *Store
+	data  []byte
+}
+
+// Decode the value into the object, obj must be a pointer
+func (v Value) Decode(obj interface{}) error {
+	return v.store.Decode(v.data, obj)
+}
+
+// ForEach object of the database, the query decodes the values
+// with Value.Decode into objects of the expected type
+func (s *Store) ForEach(query func(Value) error) error {
+	return s.db.ForEach(func(data []byte) error {
+		return query(Value{store: s, data: data})
+	})
+}
@@ -0,0 +1,160 @@
+package typed_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/plateausnetwork/drivers"
+	"github.com/plateausnetwork/drivers/typed"
+)
+
+var key = []byte("key")
+
+type block struct {
+	Height int
+	Hash   string
+}
+
+var testBlock = block{Height: 7, Hash: "abc"}
+
+func withStore(opts typed.Options, handler func(*typed.Store)) {
+	db, err := drivers.Open(drivers.Ristretto, "typed", drivers.Options{})
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+
+	store, err := typed.New(db, opts)
+	if err != nil {
+		panic(err)
+	}
+	handler(store)
+}
+
+func TestCodecs(t *testing.T) {
+	for _, codec := range []typed.Codec{typed.JSON, typed.Gob} {
+		withStore(typed.Options{Codec: codec}, func(store *typed.Store) {
+			if err := store.Put(key, &testBlock); err != nil {
+				t.Fatal(err)
+			}
+
+			var b block
+			if err := store.Get(key, &b); err != nil {
+				t.Fatal(err)
+			}
+			if b != testBlock {
+				t.Errorf("codec %d: expected %+v, received %+v", codec.ID(), testBlock, b)
+			}
+		})
+	}
+}
+
+func TestRaw(t *testing.T) {
+	withStore(typed.Options{Codec: typed.Raw}, func(store *typed.Store) {
+		if err := store.Put(key, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+
+		var v []byte
+		if err := store.Get(key, &v); err != nil || !bytes.Equal(v, []byte("value")) {
+			t.Errorf("the value is different than expected: %v", err)
+		}
+
+		if err := store.Put(key, testBlock); err == nil {
+			t.Error("raw codec must accept only bytes")
+		}
+	})
+}
+
+func TestForEach(t *testing.T) {
+	withStore(typed.Options{Codec: typed.JSON}, func(store *typed.Store) {
+		for i := 0; i < 3; i++ {
+			if err := store.Put([]byte{byte(i)}, block{Height: i}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var list []block
+		err := store.ForEach(func(v typed.Value) error {
+			var b block
+			err := v.Decode(&b)
+			list = append(list, b)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, b := range list {
+			if b.Height != i {
+				t.Errorf("expected height %d, received %d", i, b.Height)
+			}
+		}
+	})
+}
+
+func TestCodecChange(t *testing.T) {
+	withStore(typed.Options{Codec: typed.JSON}, func(store *typed.Store) {
+		if err := store.Put(key, testBlock); err != nil {
+			t.Fatal(err)
+		}
+
+		// a new store with another codec reads the old values
+		gobStore, err := typed.New(store.DB(), typed.Options{Codec: typed.Gob})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b block
+		if err := gobStore.Get(key, &b); err != nil || b != testBlock {
+			t.Errorf("the old value must be read with its codec: %v", err)
+		}
+	})
+}
+
+func TestUpgrade(t *testing.T) {
+	withStore(typed.Options{Codec: typed.JSON}, func(store *typed.Store) {
+		if err := store.DB().Upsert(key, append([]byte{typed.JSONID, 0}, `{"Height":"7"}`...)); err != nil {
+			t.Fatal(err)
+		}
+
+		// version 1 changed the height from string to int
+		upgraded, err := typed.New(store.DB(), typed.Options{
+			Codec:   typed.JSON,
+			Version: 1,
+			Upgrade: func(version byte, data []byte) ([]byte, error) {
+				return bytes.Replace(data, []byte(`"7"`), []byte(`7`), 1), nil
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var b block
+		if err := upgraded.Get(key, &b); err != nil || b.Height != 7 {
+			t.Errorf("the old version must be upgraded: %v", err)
+		}
+	})
+}
+
+func TestDecodeErrors(t *testing.T) {
+	withStore(typed.Options{Codec: typed.JSON}, func(store *typed.Store) {
+		var b block
+		if err := store.Decode([]byte{99, 0}, &b); !errors.Is(err, typed.ErrUnknownCodec) {
+			t.Errorf("expected %v, received %v", typed.ErrUnknownCodec, err)
+		}
+
+		if err := store.Decode([]byte{}, &b); err != typed.ErrInvalidValue {
+			t.Errorf("expected %v, received %v", typed.ErrInvalidValue, err)
+		}
+
+		if err := store.Get([]byte("inexistent"), &b); !errors.Is(err, drivers.ErrNotFound) {
+			t.Errorf("expected %v, received %v", drivers.ErrNotFound, err)
+		}
+	})
+
+	if _, err := typed.New(nil, typed.Options{}); err == nil {
+		t.Error("the codec is required")
+	}
+}
@@ -0,0 +1,233 @@
+package drivers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidURL is matched by the errors of ParseURL
+var ErrInvalidURL = errors.New("invalid database URL")
+
+// URLError of a part of the URL, Param is the name of the parameter or
+// scheme, host and path
+type URLError struct {
+	Param string
+	Value string
+	Err   error
+}
+
+func (e *URLError) Error() string {
+	return fmt.Sprintf("%v: %s %q: %v", ErrInvalidURL, e.Param, e.Value, e.Err)
+}
+
+// Is matches ErrInvalidURL
+func (e *URLError) Is(target error) bool {
+	return target == ErrInvalidURL
+}
+
+// Unwrap returns the error of the value
+func (e *URLError) Unwrap() error {
+	return e.Err
+}
+
+// URL of a database, the scheme is the driver
+// bolt:///var/data/chain.db?bucket=rhz&timeout=5s
+// badger:///var/data/state?nosync=true or badger:relative/dir
+// ristretto://name?maxcost=1e8
+// parameters:
+// bucket: bolt bucket, DefaultOptions.Bucket if absent
+// timeout: bolt, max time waiting the file lock, like 5s
+// readonly, nosync: bolt and badger, true or false
+// inmemory: true or false, badger v1 has no memory mode and Open returns ErrNotSupported
+// mode: bolt and badger, mode of the file or directory in octal, like 0640
+// maxcost: ristretto, max size of the values, like 1e8
+type URL struct {
+	Driver  DriverType
+	Path    string // name of the ristretto cache
+	Options Options
+}
+
+// parameters of each driver
+var urlParams = map[DriverType][]string{
+	Boltdb:    {"bucket", "timeout", "readonly", "nosync", "mode"},
+	Badgerdb:  {"readonly", "nosync", "inmemory", "mode"},
+	Ristretto: {"maxcost"},
+}
+
+// OpenURL opens the database of the URL, see URL
+func OpenURL(rawurl string) (KeyValueDB, error) {
+	u, err := ParseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return Open(u.Driver, u.Path, u.Options)
+}
+
+// ParseURL returns the driver, path and options of the URL
+// the errors are *URLError, matched by ErrInvalidURL
+func ParseURL(rawurl string) (*URL, error) {
+	parsed, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, &URLError{Param: "url", Value: rawurl, Err: errors.Unwrap(err)}
+	}
+
+	u, err := newURL(parsed.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Driver == Ristretto {
+		if parsed.Path != "" || parsed.Opaque != "" {
+			return nil, &URLError{Param: "path", Value: parsed.Path + parsed.Opaque, Err: errors.New("the cache has only a name")}
+		}
+		u.Path = parsed.Host
+	} else {
+		if parsed.Host != "" {
+			return nil, &URLError{Param: "host", Value: parsed.Host, Err: errors.New("must be empty, the absolute paths start with ///")}
+		}
+		u.Path = parsed.Path
+		if parsed.Opaque != "" {
+			if u.Path, err = url.PathUnescape(parsed.Opaque); err != nil {
+				return nil, &URLError{Param: "path", Value: parsed.Opaque, Err: err}
+			}
+		}
+	}
+
+	query, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return nil, &URLError{Param: "query", Value: parsed.RawQuery, Err: err}
+	}
+	for name, values := range query {
+		if len(values) > 1 {
+			return nil, &URLError{Param: name, Value: values[1], Err: errors.New("repeated parameter")}
+		}
+		if err := u.setParam(name, values[0]); err != nil {
+			return nil, err
+		}
+	}
+	return u, u.validate()
+}
+
+// newURL returns the URL of the driver with the default options
+func newURL(driver string) (*URL, error) {
+	switch driver {
+	case "bolt":
+		return &URL{Driver: Boltdb, Options: Options{Bucket: DefaultOptions.Bucket}}, nil
+	case "badger":
+		return &URL{Driver: Badgerdb}, nil
+	case "ristretto":
+		return &URL{Driver: Ristretto}, nil
+	}
+	return nil, &URLError{Param: "scheme", Value: driver, Err: errors.New("unknown driver")}
+}
+
+// setParam sets the option of the parameter of the driver
+func (u *URL) setParam(name, value string) error {
+	valid := false
+	for _, param := range urlParams[u.Driver] {
+		valid = valid || param == name
+	}
+	if !valid {
+		return &URLError{Param: name, Value: value, Err: fmt.Errorf("not a parameter of %s", u.Driver)}
+	}
+	if err := u.Options.set(name, value); err != nil {
+		return &URLError{Param: name, Value: value, Err: err}
+	}
+	return nil
+}
+
+// validate the URL after all parameters
+func (u *URL) validate() error {
+	if u.Path == "" && !u.Options.InMemory {
+		return &URLError{Param: "path", Err: errors.New("empty path")}
+	}
+	return nil
+}
+
+// set the option of the parameter
+func (op *Options) set(name, value string) error {
+	var err error
+	switch name {
+	case "bucket":
+		if value == "" {
+			return errors.New("empty bucket")
+		}
+		op.Bucket = []byte(value)
+	case "timeout":
+		var d time.Duration
+		if d, err = time.ParseDuration(value); err == nil && d < 0 {
+			err = errors.New("negative duration")
+		}
+		op.Timeout = int64(d)
+	case "readonly":
+		op.ReadOnly, err = strconv.ParseBool(value)
+	case "nosync":
+		op.NoSync, err = strconv.ParseBool(value)
+	case "inmemory":
+		op.InMemory, err = strconv.ParseBool(value)
+	case "mode":
+		var mode uint64
+		mode, err = strconv.ParseUint(value, 8, 32)
+		if err == nil && mode > 0777 {
+			err = errors.New("only the permission bits")
+		}
+		op.FileMode = os.FileMode(mode)
+	case "maxcost":
+		// the exponent notation is accepted, like 1e8
+		var cost float64
+		cost, err = strconv.ParseFloat(value, 64)
+		if err == nil && (cost <= 0 || cost != math.Trunc(cost) || cost > math.MaxInt64) {
+			err = errors.New("must be a positive integer")
+		}
+		op.Size = int64(cost)
+	}
+	if numErr, ok := err.(*strconv.NumError); ok {
+		err = numErr.Err
+	}
+	return err
+}
+
+// String returns the URL, ParseURL returns the same URL of it
+func (u *URL) String() string {
+	query := url.Values{}
+	op := u.Options
+	if u.Driver == Boltdb {
+		query.Set("bucket", string(op.Bucket))
+		if op.Timeout != 0 {
+			query.Set("timeout", time.Duration(op.Timeout).String())
+		}
+	}
+	if op.ReadOnly {
+		query.Set("readonly", "true")
+	}
+	if op.NoSync {
+		query.Set("nosync", "true")
+	}
+	if op.InMemory {
+		query.Set("inmemory", "true")
+	}
+	if op.FileMode != 0 {
+		query.Set("mode", fmt.Sprintf("%#o", uint32(op.FileMode)))
+	}
+	if op.Size != 0 {
+		query.Set("maxcost", strconv.FormatInt(op.Size, 10))
+	}
+
+	out := url.URL{Scheme: u.Driver.String(), RawQuery: query.Encode()}
+	switch {
+	case u.Driver == Ristretto:
+		out.Host = u.Path
+	case filepath.IsAbs(u.Path):
+		out.Path = u.Path
+	default:
+		// the relative paths are opaque, with the slashes
+		out.Opaque = (&url.URL{Path: u.Path}).EscapedPath()
+	}
+	return out.String()
+}
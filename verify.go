@@ -0,0 +1,87 @@
+package drivers
+
+import (
+	"github.com/plateausnetwork/drivers/dbtx"
+)
+
+// salvageBatch is the amount of key/values written by each transaction of Salvage
+var salvageBatch = 1000
+
+// Verify checks the database and returns the problems found
+// the drivers without Verifier are checked by reading all key/values
+func Verify(db Reader) (*Verification, error) {
+	if verifier, ok := db.(Verifier); ok {
+		return verifier.Verify()
+	}
+	report := &Verification{}
+	err := readAll(db, report, func(key, value []byte) error {
+		return nil
+	})
+	return report, err
+}
+
+// Salvage copies the readable key/values of src to dst and reports the ones that can't be read
+// the keys after a failed iteration are lost, the error is only returned if dst can't be written
+func Salvage(src Reader, dst KeyValueDB) (*Verification, error) {
+	report := &Verification{}
+	var batch []dbtx.KeyValue
+	flush := func() error {
+		err := dst.Update(func(bkt dbtx.Bucket) error {
+			for _, kv := range batch {
+				if err := bkt.Put(kv.Key, kv.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	err := readAll(src, report, func(key, value []byte) error {
+		batch = append(batch, dbtx.KeyValue{Key: key, Value: value})
+		if len(batch) < salvageBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// readAll calls fn with each readable key/value, the others are added to the report
+// the panics of the corrupted databases are reported as problems
+func readAll(db Reader, report *Verification, fn func(key, value []byte) error) error {
+	var keys [][]byte
+	err := dbtx.Safely(func() error {
+		return db.KeyIterator(func(key []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		report.Add("scan", nil, err)
+	}
+
+	for _, key := range keys {
+		var value []byte
+		err := dbtx.Safely(func() (err error) {
+			value, err = db.Get(key)
+			return err
+		})
+		if err != nil {
+			report.Add("read", key, err)
+			continue
+		}
+		report.Keys++
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+/*
+	Package watch delivers the changes committed in the drivers to the subscribers
+	of a key prefix.
+	The events are delivered in the order of the commits, by a bounded buffer
+	for each subscriber. If a subscriber is too slow and its buffer is full,
+	the subscriber is dropped and its channel is closed, so it never misses
+	events silently: it must Watch again and reload the state it needs.
+*/
+
+package watch
+
+import (
+	"bytes"
+	"sync"
+)
+
+// DefaultBuffer is the amount of events buffered for each subscriber
+var DefaultBuffer = 1024
+
+// Op is the operation of the event
+type Op int
+
+// operations of the events
+const (
+	Put Op = iota
+	Delete
+)
+
+func (op Op) String() string {
+	if op == Delete {
+		return "delete"
+	}
+	return "put"
+}
+
+// Event of a committed change, Value is nil in deletes
+type Event struct {
+	Op    Op
+	Key   []byte
+	Value []byte
+}
+
+type subscriber struct {
+	prefix []byte
+	ch     chan Event
+}
+
+// Hub of the subscribers of one database
+type Hub struct {
+	subs   map[*subscriber]struct{}
+	closed bool
+	commit sync.Mutex // keeps the order of the commits and events
+	sync.Mutex
+}
+
+// NewHub returns the hub without subscribers
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*subscriber]struct{})}
+}
+
+// Watch returns the events of the keys with the prefix and the function to cancel it
+// the channel is closed by cancel, by the close of the database or if the subscriber is too slow
+// it waits the running Commit, so a Commit that checked Watching before the
+// subscription has returned when Watch returns
+func (h *Hub) Watch(prefix []byte) (<-chan Event, func()) {
+	sub := &subscriber{
+		prefix: append([]byte{}, prefix...),
+		ch:     make(chan Event, DefaultBuffer),
+	}
+
+	h.commit.Lock()
+	defer h.commit.Unlock()
+	h.Lock()
+	defer h.Unlock()
+	if h.closed {
+		close(sub.ch)
+		return sub.ch, func() {}
+	}
+	h.subs[sub] = struct{}{}
+
+	return sub.ch, func() {
+		h.Lock()
+		defer h.Unlock()
+		h.drop(sub)
+	}
+}
+
+// drop the subscriber, the lock must be held
+func (h *Hub) drop(sub *subscriber) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Watching returns true if there are subscribers
+// the drivers can skip the events when nobody is watching, if they check it
+// inside Commit or after the write was committed
+func (h *Hub) Watching() bool {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.subs) > 0
+}
+
+// Publish the events to the subscribers of their prefixes
+// the slow subscribers are dropped
+func (h *Hub) Publish(events ...Event) {
+	h.Lock()
+	defer h.Unlock()
+	for sub := range h.subs {
+		h.send(sub, events)
+	}
+}
+
+// send the events to the subscriber or drop it if the buffer is full
+func (h *Hub) send(sub *subscriber, events []Event) {
+	for _, e := range events {
+		if !bytes.HasPrefix(e.Key, sub.prefix) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			h.drop(sub)
+			return
+		}
+	}
+}
+
+// Commit runs the write and publishes its events, if there is no error
+// the commits are serialized, so the events are published in the order of the commits
+func (h *Hub) Commit(write func() ([]Event, error)) error {
+	h.commit.Lock()
+	defer h.commit.Unlock()
+
+	events, err := write()
+	if err != nil {
+		return err
+	}
+	if len(events) > 0 {
+		h.Publish(events...)
+	}
+	return nil
+}
+
+// Close drops all subscribers, the next ones are closed when they Watch
+func (h *Hub) Close() {
+	h.Lock()
+	defer h.Unlock()
+	h.closed = true
+	for sub := range h.subs {
+		h.drop(sub)
+	}
+}
+
+// Recorder returns the events of the puts and deletes of one transaction
+// the keys and values are copied, so they can be used after the transaction
+type Recorder struct {
+	Events []Event
+}
+
+// Put records the put event
+func (r *Recorder) Put(key, value []byte) {
+	r.Events = append(r.Events, Event{
+		Op:    Put,
+		Key:   append([]byte{}, key...),
+		Value: append([]byte{}, value...),
+	})
+}
+
+// Delete records the delete event
+func (r *Recorder) Delete(key []byte) {
+	r.Events = append(r.Events, Event{Op: Delete, Key: append([]byte{}, key...)})
+}
@@ -0,0 +1,125 @@
+package watch_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/plateausnetwork/drivers/watch"
+)
+
+func put(key string) watch.Event {
+	return watch.Event{Op: watch.Put, Key: []byte(key), Value: []byte("v" + key)}
+}
+
+func TestPrefix(t *testing.T) {
+	hub := watch.NewHub()
+	events, cancel := hub.Watch([]byte("b"))
+	defer cancel()
+
+	hub.Publish(put("a"), put("b"), put("b1"), put("c"))
+
+	for _, key := range []string{"b", "b1"} {
+		if e := <-events; string(e.Key) != key {
+			t.Errorf("expected %q, got %q", key, e.Key)
+		}
+	}
+	select {
+	case e := <-events:
+		t.Errorf("unexpected event of %q", e.Key)
+	default:
+	}
+}
+
+func TestCommit(t *testing.T) {
+	hub := watch.NewHub()
+	events, cancel := hub.Watch(nil)
+	defer cancel()
+
+	failed := errors.New("failed")
+	err := hub.Commit(func() ([]watch.Event, error) {
+		return []watch.Event{put("a")}, failed
+	})
+	if err != failed {
+		t.Errorf("expected the error of the write, got %v", err)
+	}
+	if err := hub.Commit(func() ([]watch.Event, error) {
+		return []watch.Event{put("b")}, nil
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if e := <-events; string(e.Key) != "b" {
+		t.Errorf("the failed commit was published: %q", e.Key)
+	}
+}
+
+// the commit that skipped the events ends before Watch returns
+func TestWatchDuringCommit(t *testing.T) {
+	hub := watch.NewHub()
+	started, release := make(chan struct{}), make(chan struct{})
+	committed := false
+	go hub.Commit(func() ([]watch.Event, error) { //nolint:errcheck
+		if hub.Watching() {
+			t.Error("nobody is watching")
+		}
+		close(started)
+		<-release
+		committed = true
+		return nil, nil
+	})
+	<-started
+
+	watching := make(chan struct{})
+	go func() {
+		_, cancel := hub.Watch(nil)
+		defer cancel()
+		if !committed {
+			t.Error("Watch returned before the commit")
+		}
+		close(watching)
+	}()
+	select {
+	case <-watching:
+		t.Fatal("Watch must wait the commit")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-watching
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	hub := watch.NewHub()
+	slow, cancel := hub.Watch(nil)
+	defer cancel()
+
+	for i := 0; i <= watch.DefaultBuffer; i++ {
+		hub.Publish(put("a"))
+	}
+
+	received := 0
+	for range slow {
+		received++
+	}
+	if received != watch.DefaultBuffer {
+		t.Errorf("expected %d buffered events, got %d", watch.DefaultBuffer, received)
+	}
+	if hub.Watching() {
+		t.Error("the slow subscriber wasn't dropped")
+	}
+}
+
+func TestClose(t *testing.T) {
+	hub := watch.NewHub()
+	events, cancel := hub.Watch(nil)
+	hub.Close()
+	cancel() // no effect after close
+
+	if _, ok := <-events; ok {
+		t.Error("the channel wasn't closed")
+	}
+	events, _ = hub.Watch(nil)
+	if _, ok := <-events; ok {
+		t.Error("the watch after close must be closed")
+	}
+}